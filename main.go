@@ -12,10 +12,14 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
-	"time"
 
 	"buddy-agent/cmd/chatcli"
+	"buddy-agent/service/agent"
+	"buddy-agent/service/config"
+	"buddy-agent/service/dbservice"
 	"buddy-agent/service/httpserver"
+	"buddy-agent/service/users"
+	"buddy-agent/service/worker"
 )
 
 func main() {
@@ -26,44 +30,173 @@ func main() {
 
 	chatMode := flag.Bool("chat", false, "Run the interactive chat CLI")
 	serviceMode := flag.Bool("service", false, "Run the HTTP service listener")
-	apiKey := flag.String("api-key", os.Getenv("GOOGLE_API_KEY"), "Google API key for the Generative Language API (use GOOGLE_API_KEY)")
-	model := flag.String("model", os.Getenv("GOOGLE_CHAT_MODEL"), "Google Generative Language model (default gemini-1.5-flash-latest)")
-	role := flag.String("role", "user", "Role used for user prompts")
-	timeout := flag.Duration("timeout", 2*time.Minute, "Per-request timeout")
-	firebaseDBURL := flag.String("firebase-db-url", os.Getenv("FIREBASE_DATABASE_URL"), "Firebase Realtime Database URL (use FIREBASE_DATABASE_URL)")
-	serviceAddr := flag.String("service-addr", defaultServiceAddr(), "HTTP service listen address (use SERVICE_ADDR)")
+	workerMode := flag.Bool("worker", false, "Run a background job worker without serving HTTP")
+	gcMode := flag.Bool("gc-blobs", false, "Delete stored images that no document references, then exit")
+	gcGrace := flag.Duration("gc-grace", agent.DefaultGCGrace, "Minimum age of an unreferenced image before --gc-blobs deletes it")
+	gcDryRun := flag.Bool("gc-dry-run", false, "With --gc-blobs, list the images that would be deleted without deleting them")
+	indexMode := flag.Bool("ensure-indexes", false, "Create missing Mongo indexes and report drift from the index registry, then exit")
+	indexDryRun := flag.Bool("index-dry-run", false, "With --ensure-indexes, only report drift; exits non-zero when there is any")
+	indexReplace := flag.Bool("index-replace", false, "With --ensure-indexes, drop and rebuild indexes whose definition changed")
+	migrateMode := flag.Bool("migrate", false, "Apply pending Mongo schema migrations, then exit")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "With --migrate, list pending migrations and the documents they would change without applying them")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted, then exit")
+	loader := config.NewLoader(flag.CommandLine)
 	flag.Parse()
 
-	if *chatMode && *serviceMode {
-		log.Fatal("cannot run chat CLI and service simultaneously")
+	if countTrue(*chatMode, *serviceMode, *workerMode, *gcMode, *indexMode, *migrateMode) > 1 {
+		log.Fatal("choose only one of --chat, --service, --worker, --gc-blobs, --ensure-indexes, or --migrate")
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	if *printConfig {
+		if err := cfg.Write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *serviceMode || *workerMode {
+		if err := cfg.RequireBackends(); err != nil {
+			log.Fatalf("invalid configuration:\n%v", err)
+		}
 	}
 
 	if *serviceMode {
 		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 		defer stop()
-		cfg := httpserver.Config{Addr: *serviceAddr}
-		if err := httpserver.Run(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
+		if err := httpserver.Run(ctx, cfg.HTTPServerConfig()); err != nil && !errors.Is(err, context.Canceled) {
 			log.Fatal(err)
 		}
 		return
 	}
 
-	if *chatMode {
-		cfg := chatcli.Config{
-			APIKey:              *apiKey,
-			Model:               *model,
-			Role:                *role,
-			Timeout:             *timeout,
-			FirebaseDatabaseURL: *firebaseDBURL,
+	if *workerMode {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := worker.Run(ctx, cfg.WorkerConfig()); err != nil && !errors.Is(err, context.Canceled) {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *gcMode {
+		if err := cfg.RequireMongo(); err != nil {
+			log.Fatalf("invalid configuration:\n%v", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := runBlobGC(ctx, cfg.AgentConfig(), agent.GCOptions{Grace: *gcGrace, DryRun: *gcDryRun}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *indexMode {
+		if err := cfg.RequireMongo(); err != nil {
+			log.Fatalf("invalid configuration:\n%v", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		drift, err := runEnsureIndexes(ctx, cfg.Mongo, dbservice.IndexOptions{DryRun: *indexDryRun, Replace: *indexReplace})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if drift && *indexDryRun {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *migrateMode {
+		if err := cfg.RequireMongo(); err != nil {
+			log.Fatalf("invalid configuration:\n%v", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := runMigrations(ctx, cfg.Mongo, dbservice.MigrateOptions{DryRun: *migrateDryRun}); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
 
-		if err := chatcli.Run(context.Background(), cfg); err != nil {
+	if *chatMode {
+		if err := chatcli.Run(context.Background(), cfg.ChatConfig()); err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 
-	fmt.Println("No mode selected. Run again with --chat to start the chat CLI.")
+	fmt.Println("No mode selected. Run again with --chat, --service, --worker, --gc-blobs, --ensure-indexes, or --migrate.")
+}
+
+// runBlobGC deletes unreferenced images from storage and prints what it removed.
+func runBlobGC(ctx context.Context, cfg agent.Config, opts agent.GCOptions) error {
+	agentHandler, err := agent.NewStorageHandler(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("init agent handler: %w", err)
+	}
+	defer agentHandler.Close(context.Background())
+
+	report, err := agentHandler.CollectGarbage(ctx, opts)
+	verb := "deleted"
+	if opts.DryRun {
+		verb = "would delete"
+	}
+	for _, key := range report.Deleted {
+		fmt.Printf("%s %s\n", verb, key)
+	}
+	fmt.Printf("scanned %d objects: %d referenced, %d within grace period, %s %d (%d bytes)\n",
+		report.Scanned, report.Referenced, report.Recent, verb, len(report.Deleted), report.DeletedBytes)
+	if err != nil {
+		return fmt.Errorf("collect garbage: %w", err)
+	}
+	return nil
+}
+
+// runEnsureIndexes reconciles every collection with the index registry, prints the
+// drift it found and reports whether there was any.
+func runEnsureIndexes(ctx context.Context, cfg dbservice.Config, opts dbservice.IndexOptions) (bool, error) {
+	db, err := dbservice.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return false, fmt.Errorf("connect to mongo: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	registry := append(users.Indexes(), agent.Indexes()...)
+	report, err := db.EnsureIndexes(ctx, registry, opts)
+	for _, line := range report.Lines() {
+		fmt.Println(line)
+	}
+	if !report.Drift() {
+		fmt.Println("indexes match the registry")
+	}
+	if err != nil {
+		return true, fmt.Errorf("ensure indexes: %w", err)
+	}
+	return report.Drift(), nil
+}
+
+// runMigrations applies, or with DryRun lists, the pending schema migrations.
+func runMigrations(ctx context.Context, cfg dbservice.Config, opts dbservice.MigrateOptions) error {
+	db, err := dbservice.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("connect to mongo: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	report, err := db.Migrate(ctx, agent.Migrations(), opts)
+	for _, line := range report.Lines() {
+		fmt.Println(line)
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	if len(report.Pending)+len(report.Applied) == 0 {
+		fmt.Println("no pending migrations")
+	}
+	return nil
 }
 
 func loadDotEnv(path string) error {
@@ -121,13 +254,12 @@ func ensureDefaultCredentials(path string) {
 	_ = os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", abs)
 }
 
-func defaultServiceAddr() string {
-	addr := strings.TrimSpace(os.Getenv("PORT"))
-	if addr == "" {
-		return ":3000"
-	}
-	if strings.Contains(addr, ":") || strings.HasPrefix(addr, ":") {
-		return addr
+func countTrue(values ...bool) int {
+	n := 0
+	for _, v := range values {
+		if v {
+			n++
+		}
 	}
-	return ":" + addr
+	return n
 }
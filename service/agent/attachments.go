@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"buddy-agent/service/imageproc"
+	"buddy-agent/service/llmservice"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxChatAttachments  = 4
+	maxAttachmentBytes  = 8 << 20
+	attachmentFormField = "images"
+)
+
+// attachmentTypes are the image types accepted from users: those imageproc can decode, so
+// that every attachment is re-encoded without its metadata before it is stored.
+var attachmentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// chatAttachment is an image the user sent with a chat message. Uploaded files have no
+// URL until they are stored.
+type chatAttachment struct {
+	URL      string
+	Data     []byte
+	MIMEType string
+}
+
+// readChatRequest accepts either a JSON chatRequest, whose attachments reference images
+// already stored for this agent, or a multipart form with a prompt field and image files.
+func (h *AgentHandler) readChatRequest(ctx context.Context, r *http.Request, agentID primitive.ObjectID) (string, []chatAttachment, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return readMultipartChat(r)
+	}
+
+	var req chatRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return "", nil, newRequestError(http.StatusBadRequest, "invalid json: %v", err)
+	}
+	if len(req.Attachments) > maxChatAttachments {
+		return "", nil, newRequestError(http.StatusBadRequest, "at most %d attachments are allowed", maxChatAttachments)
+	}
+	if len(req.Attachments) > 0 && h.storage == nil {
+		return "", nil, newRequestError(http.StatusServiceUnavailable, "storage service not initialized")
+	}
+	attachments := make([]chatAttachment, 0, len(req.Attachments))
+	for _, uri := range req.Attachments {
+		// Clients only see resolved URLs, which may carry an expiring signature; persist
+		// the stored form instead.
+		stored, err := h.storedURL(uri)
+		if err != nil {
+			return "", nil, newRequestError(http.StatusBadRequest, "attachment %q was not uploaded to this agent", uri)
+		}
+		// Only images stored for this agent may be referenced again.
+		owned, err := h.agentOwnsBlob(ctx, agentID, stored)
+		if err != nil {
+			return "", nil, newRequestError(http.StatusInternalServerError, "failed to check attachment %q: %v", uri, err)
+		}
+		if !owned {
+			return "", nil, newRequestError(http.StatusBadRequest, "attachment %q was not uploaded to this agent", uri)
+		}
+		readCtx, cancel := context.WithTimeout(ctx, imageRequestTimeout)
+		data, mimeType, err := h.storage.ReadImage(readCtx, stored)
+		cancel()
+		if err != nil {
+			return "", nil, newRequestError(http.StatusBadRequest, "failed to load attachment %q: %v", uri, err)
+		}
+		attachments = append(attachments, chatAttachment{URL: stored, Data: data, MIMEType: mimeType})
+	}
+	return req.Prompt, attachments, nil
+}
+
+func readMultipartChat(r *http.Request) (string, []chatAttachment, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxChatAttachments*maxAttachmentBytes+1<<20)
+	if err := r.ParseMultipartForm(maxAttachmentBytes); err != nil {
+		return "", nil, newRequestError(http.StatusBadRequest, "invalid multipart form: %v", err)
+	}
+	files := r.MultipartForm.File[attachmentFormField]
+	if len(files) > maxChatAttachments {
+		return "", nil, newRequestError(http.StatusBadRequest, "at most %d attachments are allowed", maxChatAttachments)
+	}
+	attachments := make([]chatAttachment, 0, len(files))
+	for _, header := range files {
+		if header.Size > maxAttachmentBytes {
+			return "", nil, newRequestError(http.StatusRequestEntityTooLarge, "%s exceeds %d bytes", header.Filename, maxAttachmentBytes)
+		}
+		file, err := header.Open()
+		if err != nil {
+			return "", nil, newRequestError(http.StatusBadRequest, "failed to read %s: %v", header.Filename, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(file, maxAttachmentBytes+1))
+		file.Close()
+		if err != nil {
+			return "", nil, newRequestError(http.StatusBadRequest, "failed to read %s: %v", header.Filename, err)
+		}
+		if len(data) > maxAttachmentBytes {
+			return "", nil, newRequestError(http.StatusRequestEntityTooLarge, "%s exceeds %d bytes", header.Filename, maxAttachmentBytes)
+		}
+		// Trust the bytes, not the client's declared type.
+		mimeType := http.DetectContentType(data)
+		if !attachmentTypes[mimeType] {
+			return "", nil, newRequestError(http.StatusUnsupportedMediaType, "%s is not a supported image type", header.Filename)
+		}
+		// Re-encode like every other stored image, so EXIF and GPS data never reach the
+		// model, the store or other clients.
+		outputs, err := imageproc.Process(data, imageproc.Options{Renditions: imageproc.DefaultRenditions[len(imageproc.DefaultRenditions)-1:]})
+		if err != nil {
+			return "", nil, newRequestError(http.StatusUnsupportedMediaType, "%s is not a valid image: %v", header.Filename, err)
+		}
+		attachments = append(attachments, chatAttachment{Data: outputs[0].Data, MIMEType: outputs[0].MIMEType})
+	}
+	return r.FormValue("prompt"), attachments, nil
+}
+
+// storeAttachments uploads attachments that arrived as files, already cleaned by
+// readMultipartChat, and fills in their URLs.
+func (h *AgentHandler) storeAttachments(ctx context.Context, agentID primitive.ObjectID, attachments []chatAttachment) error {
+	for i := range attachments {
+		if attachments[i].URL != "" {
+			continue
+		}
+		url, err := h.uploadAgentImage(ctx, agentID, attachments[i].MIMEType, attachments[i].Data)
+		if err != nil {
+			return fmt.Errorf("store attachment: %w", err)
+		}
+		attachments[i].URL = url
+	}
+	return nil
+}
+
+func attachmentImages(attachments []chatAttachment) []llmservice.Image {
+	images := make([]llmservice.Image, 0, len(attachments))
+	for _, a := range attachments {
+		images = append(images, llmservice.Image{Data: a.Data, MIMEType: a.MIMEType})
+	}
+	return images
+}
+
+func attachmentURLs(attachments []chatAttachment) []string {
+	urls := make([]string, 0, len(attachments))
+	for _, a := range attachments {
+		urls = append(urls, a.URL)
+	}
+	return urls
+}
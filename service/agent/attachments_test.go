@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"buddy-agent/service/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// jpegWithExif returns a small JPEG carrying an EXIF segment with a marker string.
+func jpegWithExif(t *testing.T, marker string) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	segment := append([]byte("Exif\x00\x00"), marker...)
+	app1 := []byte{0xFF, 0xE1, byte((len(segment) + 2) >> 8), byte(len(segment) + 2)}
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func multipartChatRequest(t *testing.T, prompt string, files ...[]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("prompt", prompt); err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range files {
+		part, err := form.CreateFormFile(attachmentFormField, "photo"+string(rune('a'+i))+".jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "/chat", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func requestStatus(err error) int {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr.status
+	}
+	return 0
+}
+
+func TestReadMultipartChatStripsMetadata(t *testing.T) {
+	original := jpegWithExif(t, "GPS 38.7N 9.1W")
+	prompt, attachments, err := readMultipartChat(multipartChatRequest(t, "look", original))
+	if err != nil {
+		t.Fatalf("readMultipartChat: %v", err)
+	}
+	if prompt != "look" || len(attachments) != 1 {
+		t.Fatalf("prompt %q, %d attachments", prompt, len(attachments))
+	}
+	if attachments[0].MIMEType != "image/jpeg" || bytes.Contains(attachments[0].Data, []byte("GPS 38.7N")) {
+		t.Fatalf("attachment kept its metadata (%s, %d bytes)", attachments[0].MIMEType, len(attachments[0].Data))
+	}
+}
+
+func TestReadMultipartChatRejectsBadUploads(t *testing.T) {
+	photo := jpegWithExif(t, "x")
+	tooMany := make([][]byte, maxChatAttachments+1)
+	for i := range tooMany {
+		tooMany[i] = photo
+	}
+	cases := []struct {
+		name  string
+		files [][]byte
+		want  int
+	}{
+		{"not an image", [][]byte{[]byte("%PDF-1.4 pretending to be a jpeg")}, http.StatusUnsupportedMediaType},
+		{"truncated image", [][]byte{photo[:len(photo)/2]}, http.StatusUnsupportedMediaType},
+		{"too many", tooMany, http.StatusBadRequest},
+		{"too large", [][]byte{append(bytes.Clone(photo), make([]byte, maxAttachmentBytes)...)}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		_, _, err := readMultipartChat(multipartChatRequest(t, "hi", tc.files...))
+		if got := requestStatus(err); got != tc.want {
+			t.Errorf("%s: status %d (%v), want %d", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestReadChatRequestJSON(t *testing.T) {
+	h := &AgentHandler{storage: storage.NewMemory(storage.Config{})}
+	agentID := primitive.NewObjectID()
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"foreign uri", `{"prompt":"hi","attachments":["https://elsewhere.example/base-faces/a.png"]}`, http.StatusBadRequest},
+		{"too many", `{"prompt":"hi","attachments":["a","b","c","d","e"]}`, http.StatusBadRequest},
+		{"unknown field", `{"prompt":"hi","files":[]}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		_, _, err := h.readChatRequest(context.Background(), r, agentID)
+		if got := requestStatus(err); got != tc.want {
+			t.Errorf("%s: status %d (%v), want %d", tc.name, got, err, tc.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"prompt":"hi"}`))
+	prompt, attachments, err := h.readChatRequest(context.Background(), r, agentID)
+	if err != nil || prompt != "hi" || len(attachments) != 0 {
+		t.Fatalf("readChatRequest = %q, %v, %v", prompt, attachments, err)
+	}
+}
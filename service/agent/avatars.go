@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"buddy-agent/service/imageproc"
+)
+
+// Where an agent's base image came from.
+const (
+	imageSourceGenerated   = "generated"
+	imageSourcePlaceholder = "placeholder"
+	imageSourceUploaded    = "uploaded"
+	imageSourceCloned      = "cloned"
+)
+
+const (
+	maxAvatarBytes     = 10 << 20
+	minAvatarDimension = 256
+	maxAvatarDimension = 4096
+	maxImageHistory    = 20
+	avatarFormField    = "image"
+)
+
+// UploadAgentAvatar replaces an agent's base portrait with an image supplied by its
+// creator, either as the "image" field of a multipart form or as the raw request body.
+// The previous portrait is archived in the agent's image history.
+func (h *AgentHandler) UploadAgentAvatar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	data, err := readAvatarUpload(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if err := validateAvatar(data); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	stored, err := h.loadOwnedAgent(r.Context(), agentID, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	var archived *ImageHistoryEntry
+	if stored.BaseAppearanceReferenceURL != "" {
+		entry := replacedImage(stored)
+		archived = &entry
+	}
+	images, err := h.uploadBaseImage(r.Context(), agentID, data)
+	if err != nil {
+		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to store image: %v", err))
+		return
+	}
+	// Only replace the portrait that was archived, so concurrent uploads cannot both
+	// archive it.
+	filter := baseImageFilter(agentID, stored.BaseAppearanceReferenceURL)
+	replaced, err := h.replaceBaseImage(r.Context(), agentID, filter, archived, images, imageSourceUploaded, "")
+	if err != nil || !replaced {
+		h.releaseUploads(r.Context(), agentID, []string{images.Thumb, images.Medium, images.Full})
+	}
+	if err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to store image: %v", err))
+		return
+	}
+	if !replaced {
+		respondJSONError(w, http.StatusConflict, "the agent's portrait changed during the upload; try again")
+		return
+	}
+	if archived != nil {
+		stored.ImageHistory = append(stored.ImageHistory, *archived)
+		if len(stored.ImageHistory) > maxImageHistory {
+			stored.ImageHistory = stored.ImageHistory[len(stored.ImageHistory)-maxImageHistory:]
+		}
+	}
+
+	stored.ProfileImageURL = images.Medium
+	stored.BaseAppearanceReferenceURL = images.Full
+	stored.Images = &images
+	stored.PlaceholderImage = false
+	stored.ImageSource = imageSourceUploaded
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"agent":         h.presentAgent(r.Context(), stored),
+		"image_history": h.presentImageHistory(r.Context(), stored.ImageHistory),
+	})
+}
+
+func readAvatarUpload(r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxAvatarBytes+1<<20)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxAvatarBytes+1))
+		if err != nil {
+			return nil, newRequestError(http.StatusBadRequest, "failed to read body: %v", err)
+		}
+		if len(data) > maxAvatarBytes {
+			return nil, newRequestError(http.StatusRequestEntityTooLarge, "image must be at most %d bytes", maxAvatarBytes)
+		}
+		return data, nil
+	}
+	file, header, err := r.FormFile(avatarFormField)
+	if err != nil {
+		return nil, newRequestError(http.StatusBadRequest, "multipart field %q is required: %v", avatarFormField, err)
+	}
+	defer file.Close()
+	if header.Size > maxAvatarBytes {
+		return nil, newRequestError(http.StatusRequestEntityTooLarge, "image must be at most %d bytes", maxAvatarBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarBytes+1))
+	if err != nil {
+		return nil, newRequestError(http.StatusBadRequest, "failed to read image: %v", err)
+	}
+	if len(data) > maxAvatarBytes {
+		return nil, newRequestError(http.StatusRequestEntityTooLarge, "image must be at most %d bytes", maxAvatarBytes)
+	}
+	return data, nil
+}
+
+// validateAvatar checks the sniffed content type and the image dimensions.
+func validateAvatar(data []byte) error {
+	if len(data) == 0 {
+		return newRequestError(http.StatusBadRequest, "image is required")
+	}
+	switch http.DetectContentType(data) {
+	case "image/png", "image/jpeg", "image/gif":
+	default:
+		return newRequestError(http.StatusUnsupportedMediaType, "image must be a PNG, JPEG or GIF")
+	}
+	cfg, _, err := imageproc.Inspect(data)
+	if err != nil {
+		return newRequestError(http.StatusBadRequest, "image could not be decoded: %v", err)
+	}
+	if cfg.Width < minAvatarDimension || cfg.Height < minAvatarDimension {
+		return newRequestError(http.StatusBadRequest, "image must be at least %dx%d pixels", minAvatarDimension, minAvatarDimension)
+	}
+	if cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		return newRequestError(http.StatusBadRequest, "image must be at most %dx%d pixels", maxAvatarDimension, maxAvatarDimension)
+	}
+	return nil
+}
+
+// replacedImage records the agent's current full-size portrait for its image history.
+// Stored images are content-addressed and never overwritten, so the URL stays valid.
+func replacedImage(a Agent) ImageHistoryEntry {
+	source := a.ImageSource
+	if source == "" {
+		source = imageSourceGenerated
+	}
+	return ImageHistoryEntry{URL: a.BaseAppearanceReferenceURL, Source: source, ReplacedAt: time.Now().UTC()}
+}
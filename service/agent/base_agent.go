@@ -10,6 +10,11 @@ import (
 	"strings"
 	"time"
 
+	"buddy-agent/service/avatar"
+	"buddy-agent/service/imagegen"
+	"buddy-agent/service/imageproc"
+	"buddy-agent/service/storage"
+	userssvc "buddy-agent/service/users"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -26,78 +31,229 @@ func (h *AgentHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var payload Agent
+	var payload createAgentRequest
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&payload); err != nil {
 		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
 		return
 	}
-	payload.Name = strings.TrimSpace(payload.Name)
-	payload.Personality = strings.TrimSpace(payload.Personality)
-	payload.Gender = strings.TrimSpace(payload.Gender)
-	if payload.Name == "" || payload.Personality == "" || payload.Gender == "" {
-		respondJSONError(w, http.StatusBadRequest, "name, personality, and gender are required")
+	created, err := h.createAgent(r.Context(), creator, newAgentSpec{
+		Persona:      payload.Persona,
+		ImageOptions: payload.ImageOptions,
+	})
+	if err != nil {
+		respondError(w, err)
 		return
 	}
+	h.respondCreatedAgent(w, r, created)
+}
 
-	payload.SystemPrompt = buildSystemPrompt(payload.Name, payload.Personality, payload.Gender)
-	appearanceDescription, err := h.generateAppearanceDescription(r.Context(), payload)
-	if err != nil {
-		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to generate appearance: %v", err))
-		return
+// newAgentSpec describes an agent to run through the create pipeline.
+type newAgentSpec struct {
+	Persona Persona
+	// AppearanceDescription skips the writer LLM when the caller already has one.
+	AppearanceDescription string
+	// BaseImage is uploaded as the base portrait instead of generating one.
+	BaseImage []byte
+	// BaseImageSource records where BaseImage came from; it defaults to imageSourceUploaded.
+	BaseImageSource string
+	// ForkedFrom records the agent this one was cloned from.
+	ForkedFrom *primitive.ObjectID
+	// ImageOptions tunes the generated portrait. More than one candidate leaves the choice
+	// to the creator via SelectPortrait.
+	ImageOptions imagegen.Options
+}
+
+// createAgent validates the persona, stores the agent, generates its base appearance and
+// social profile placeholder, and queues the social profile job. Failures are returned as
+// requestErrors carrying the HTTP status to report.
+func (h *AgentHandler) createAgent(ctx context.Context, creator *userssvc.User, spec newAgentSpec) (Agent, error) {
+	persona := spec.Persona
+	persona.normalize()
+	if err := persona.validate(); err != nil {
+		return Agent{}, newRequestError(http.StatusBadRequest, "%s", validationMessage(err))
+	}
+	if err := spec.ImageOptions.Validate(); err != nil {
+		return Agent{}, newRequestError(http.StatusBadRequest, "invalid image options: %v", err)
 	}
-	agentID := primitive.NewObjectID()
-	dbCtx, dbCancel := context.WithTimeout(r.Context(), dbRequestTimeout)
-	defer dbCancel()
 
-	collection := h.db.Client().Database(mongoDatabaseName()).Collection(agentsCollection)
-	doc := bson.M{
-		"_id":                           agentID,
-		"name":                          payload.Name,
-		"personality":                   payload.Personality,
-		"gender":                        payload.Gender,
-		"system_prompt":                 payload.SystemPrompt,
-		"appearance_description":        appearanceDescription,
-		"base_appearance_referance_url": "",
-		"created_by":                    creator.ID,
-		"created_at":                    time.Now().UTC(),
-	}
-	if _, err := collection.InsertOne(dbCtx, doc); err != nil {
-		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create agent: %v", err))
-		return
+	doc := Agent{
+		ID:                    primitive.NewObjectID(),
+		Persona:               persona,
+		SystemPrompt:          compileSystemPrompt(persona),
+		AppearanceDescription: strings.TrimSpace(spec.AppearanceDescription),
+		CreatedBy:             creator.ID,
+		CreatedAt:             time.Now().UTC(),
+		Version:               1,
+		ForkedFrom:            spec.ForkedFrom,
+	}
+	if doc.AppearanceDescription == "" {
+		appearanceDescription, err := h.generateAppearanceDescription(ctx, doc)
+		if err != nil {
+			return Agent{}, newRequestError(http.StatusBadGateway, "failed to generate appearance: %v", err)
+		}
+		doc.AppearanceDescription = appearanceDescription
 	}
-	cleanupAgent := func(reason string) {
-		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), dbRequestTimeout)
-		defer cleanupCancel()
-		if _, err := collection.DeleteOne(cleanupCtx, bson.M{"_id": agentID}); err != nil {
-			log.Printf("cleanup agent %s after %s failed: %v", agentID.Hex(), reason, err)
+	agentID := doc.ID
+
+	// Images go to storage first. Storage cannot join a Mongo transaction, so if the
+	// documents fail to commit the uploads are released again.
+	source := spec.BaseImageSource
+	var images ImageRenditions
+	var prompt string
+	var err error
+	if len(spec.BaseImage) > 0 {
+		if source == "" {
+			source = imageSourceUploaded
+		}
+		images, err = h.uploadBaseImage(ctx, agentID, spec.BaseImage)
+	} else {
+		source = imageSourceGenerated
+		images, doc.PortraitCandidates, prompt, err = h.renderBaseAppearance(ctx, doc, spec.ImageOptions)
+		if err != nil {
+			// Generation failures (including safety blocks) fall back to an offline avatar so the
+			// agent can still be created; a background job retries the real portrait later.
+			log.Printf("base appearance for %s failed, using placeholder: %v", agentID.Hex(), err)
+			if placeholder, placeholderErr := h.renderPlaceholderImage(ctx, doc); placeholderErr == nil {
+				images, err = placeholder, nil
+				source = imageSourcePlaceholder
+			} else {
+				log.Printf("placeholder avatar for %s failed: %v", agentID.Hex(), placeholderErr)
+			}
 		}
 	}
-	baseImageURL, err := h.generateAndPersistBaseAppearance(r.Context(), agentID)
 	if err != nil {
-		cleanupAgent("base-appearance generation")
-		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to generate base appearance: %v", err))
-		return
+		if len(spec.BaseImage) > 0 && errors.Is(err, imageproc.ErrUnsupported) {
+			return Agent{}, newRequestError(http.StatusBadRequest, "base image is not a valid image: %v", err)
+		}
+		return Agent{}, newRequestError(http.StatusBadGateway, "failed to generate base appearance: %v", err)
 	}
-	if err := h.createInitialSocialProfile(r.Context(), agentID, payload.Name, creator.ID); err != nil {
-		cleanupAgent("social-profile placeholder")
-		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create social profile: %v", err))
-		return
+	doc.ProfileImageURL = images.Medium
+	doc.BaseAppearanceReferenceURL = images.Full
+	doc.Images = &images
+	doc.ImageSource = source
+	doc.PlaceholderImage = source == imageSourcePlaceholder
+
+	if err := h.insertNewAgent(ctx, doc, prompt); err != nil {
+		uploaded := append([]string{images.Thumb, images.Medium, images.Full}, doc.PortraitCandidates...)
+		h.releaseUploads(ctx, agentID, uploaded)
+		return Agent{}, err
+	}
+	if doc.PlaceholderImage {
+		h.enqueueAgentJob(jobBaseImage, agentID, placeholderRetryDelay)
+	}
+	h.launchSocialProfileJob(agentID)
+	return doc, nil
+}
+
+// insertNewAgent writes the agent with its first version, its social profile placeholder
+// and its gallery entry. On a replica set the writes form one transaction. A standalone
+// server writes them one by one and deletes them again if any fails; should that
+// cleanup fail too, the error says so.
+func (h *AgentHandler) insertNewAgent(ctx context.Context, doc Agent, prompt string) error {
+	username := strings.TrimSpace(doc.Name)
+	if username == "" {
+		username = "agent_" + doc.ID.Hex()
+	}
+	err := withUniqueUsername(doc.ID, username, func(username string) error {
+		if h.db.Transactions() {
+			txCtx, txCancel := context.WithTimeout(ctx, dbRequestTimeout)
+			defer txCancel()
+			return h.db.WithTransaction(txCtx, func(txCtx context.Context) error {
+				return h.writeNewAgent(txCtx, doc, username, prompt)
+			})
+		}
+		dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+		defer dbCancel()
+		return writeOrUndo(doc.ID,
+			func() error { return h.writeNewAgent(dbCtx, doc, username, prompt) },
+			func() error { return h.deleteNewAgent(context.WithoutCancel(ctx), doc.ID) })
+	})
+	if err != nil {
+		return newRequestError(http.StatusInternalServerError, "failed to create agent: %v", err)
+	}
+	return nil
+}
+
+// writeOrUndo runs write and, if it fails, undo. When undo fails as well the agent is
+// partially stored, and the error no longer matches a duplicate key: withUniqueUsername
+// must not retry with the same agent id over the leftover documents.
+func writeOrUndo(agentID primitive.ObjectID, write, undo func() error) error {
+	err := write()
+	if err == nil {
+		return nil
+	}
+	if undoErr := undo(); undoErr != nil {
+		return fmt.Errorf("%v; cleanup failed, agent %s is partially stored: %w", err, agentID.Hex(), undoErr)
+	}
+	return err
+}
+
+// writeNewAgent inserts the documents of a new agent.
+func (h *AgentHandler) writeNewAgent(ctx context.Context, doc Agent, username, prompt string) error {
+	db := h.db.Database()
+	if _, err := db.Collection(agentsCollection).InsertOne(ctx, doc); err != nil {
+		return fmt.Errorf("insert agent: %w", err)
+	}
+	if _, err := db.Collection(versionsCollection).InsertOne(ctx, newAgentVersion(doc, "", 0, doc.CreatedBy)); err != nil {
+		return fmt.Errorf("insert initial version: %w", err)
+	}
+	now := time.Now().UTC()
+	profile := AgentSocialProfile{
+		ID:        primitive.NewObjectID(),
+		AgentID:   doc.ID,
+		Username:  username,
+		CreatedBy: doc.CreatedBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := db.Collection(socialProfileCollection).InsertOne(ctx, profile); err != nil {
+		return fmt.Errorf("insert social profile: %w", err)
+	}
+	if doc.ImageSource == imageSourcePlaceholder {
+		return nil
+	}
+	kind := photoKindBase
+	if doc.ImageSource == imageSourceUploaded {
+		kind = photoKindUpload
+	}
+	photo := GalleryPhoto{
+		ID:        primitive.NewObjectID(),
+		AgentID:   doc.ID,
+		Kind:      kind,
+		URL:       doc.Images.Full,
+		Prompt:    prompt,
+		Public:    true,
+		CreatedAt: now,
+	}
+	if _, err := db.Collection(galleryCollection).InsertOne(ctx, photo); err != nil {
+		return fmt.Errorf("insert gallery photo: %w", err)
+	}
+	return nil
+}
+
+// deleteNewAgent removes whatever writeNewAgent stored for agentID.
+func (h *AgentHandler) deleteNewAgent(ctx context.Context, agentID primitive.ObjectID) error {
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	db := h.db.Database()
+	var errs []error
+	if _, err := db.Collection(agentsCollection).DeleteOne(dbCtx, bson.M{"_id": agentID}); err != nil {
+		errs = append(errs, fmt.Errorf("delete agent: %w", err))
+	}
+	for _, name := range []string{versionsCollection, socialProfileCollection, galleryCollection} {
+		if _, err := db.Collection(name).DeleteMany(dbCtx, bson.M{"agent_id": agentID}); err != nil {
+			errs = append(errs, fmt.Errorf("delete %s: %w", name, err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
+func (h *AgentHandler) respondCreatedAgent(w http.ResponseWriter, r *http.Request, created Agent) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"id":                            agentID,
-		"name":                          payload.Name,
-		"personality":                   payload.Personality,
-		"gender":                        payload.Gender,
-		"profile_image_url":             baseImageURL,
-		"appearance_description":        appearanceDescription,
-		"base_appearance_referance_url": baseImageURL,
-	})
-	h.launchSocialProfileJob(agentID)
+	_ = json.NewEncoder(w).Encode(h.presentAgent(r.Context(), created))
 }
 
 // ListAgents exposes all stored agents without revealing their system prompts.
@@ -110,7 +266,7 @@ func (h *AgentHandler) ListAgents(w http.ResponseWriter, r *http.Request) {
 	dbCtx, dbCancel := context.WithTimeout(r.Context(), dbRequestTimeout)
 	defer dbCancel()
 
-	collection := h.db.Client().Database(mongoDatabaseName()).Collection(agentsCollection)
+	collection := h.db.Database().Collection(agentsCollection)
 	cursor, err := collection.Find(dbCtx, bson.D{})
 	if err != nil {
 		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to fetch agents: %v", err))
@@ -126,15 +282,7 @@ func (h *AgentHandler) ListAgents(w http.ResponseWriter, r *http.Request) {
 
 	items := make([]agentListItem, 0, len(stored))
 	for _, a := range stored {
-		items = append(items, agentListItem{
-			ID:                         a.ID,
-			Name:                       a.Name,
-			Personality:                a.Personality,
-			Gender:                     a.Gender,
-			ProfileImageURL:            a.ProfileImageURL,
-			AppearanceDescription:      a.AppearanceDescription,
-			BaseAppearanceReferenceURL: a.BaseAppearanceReferenceURL,
-		})
+		items = append(items, h.presentAgent(r.Context(), a))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -143,6 +291,22 @@ func (h *AgentHandler) ListAgents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func newAgentListItem(a Agent) agentListItem {
+	return agentListItem{
+		ID:                         a.ID,
+		Persona:                    a.Persona,
+		Version:                    a.Version,
+		ForkedFrom:                 a.ForkedFrom,
+		ProfileImageURL:            a.ProfileImageURL,
+		AppearanceDescription:      a.AppearanceDescription,
+		BaseAppearanceReferenceURL: a.BaseAppearanceReferenceURL,
+		Images:                     a.Images,
+		PlaceholderImage:           a.PlaceholderImage,
+		ImageSource:                a.ImageSource,
+		PortraitCandidates:         a.PortraitCandidates,
+	}
+}
+
 // ChatWithAgent receives a prompt for an existing agent and forwards it to the LLM.
 func (h *AgentHandler) ChatWithAgent(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -161,22 +325,20 @@ func (h *AgentHandler) ChatWithAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req chatRequest
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&req); err != nil {
-		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+	prompt, attachments, err := h.readChatRequest(r.Context(), r, agentID)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
-	req.Prompt = strings.TrimSpace(req.Prompt)
-	if req.Prompt == "" {
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
 		respondJSONError(w, http.StatusBadRequest, "prompt is required")
 		return
 	}
 
 	dbCtx, dbCancel := context.WithTimeout(r.Context(), dbRequestTimeout)
 	defer dbCancel()
-	collection := h.db.Client().Database(mongoDatabaseName()).Collection(agentsCollection)
+	collection := h.db.Database().Collection(agentsCollection)
 	var stored Agent
 	if err := collection.FindOne(dbCtx, bson.M{"_id": agentID}).Decode(&stored); err != nil {
 		status := http.StatusInternalServerError
@@ -189,20 +351,43 @@ func (h *AgentHandler) ChatWithAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	combinedPrompt := buildChatPrompt(stored.SystemPrompt, req.Prompt)
+	if err := h.storeAttachments(r.Context(), agentID, attachments); err != nil {
+		respondJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	intent := h.detectImageIntent(r.Context(), prompt)
+	userPrompt := prompt
+	if intent.SendImage {
+		userPrompt = withImageNote(userPrompt, intent.Scene)
+	}
+	combinedPrompt := buildChatPrompt(stored.SystemPrompt, userPrompt)
 	llmCtx, llmCancel := context.WithTimeout(r.Context(), llmRequestTimeout)
 	defer llmCancel()
 
-	response, err := h.llm.SendPrompt(llmCtx, "user", combinedPrompt)
+	response, err := h.llm.SendPromptWithImages(llmCtx, "user", combinedPrompt, attachmentImages(attachments))
 	if err != nil {
 		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to fetch response: %v", err))
 		return
 	}
+	parts := []messagePart{{Type: "text", Text: response}}
+	if intent.SendImage {
+		// A failed picture should not cost the user the text reply.
+		if imagePart, err := h.generateChatImage(r.Context(), stored, intent.Scene); err != nil {
+			log.Printf("chat image for %s failed: %v", agentIDHex, err)
+		} else {
+			parts = append(parts, imagePart)
+		}
+	}
+	h.recordChatMessage(r.Context(), stored, prompt, attachmentURLs(attachments), parts)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]any{
-		"agent_id": agentIDHex,
-		"response": response,
+		"agent_id":      agentIDHex,
+		"agent_version": stored.Version,
+		"response":      response,
+		"parts":         h.presentParts(r.Context(), parts),
+		"attachments":   h.resolveURLs(r.Context(), attachmentURLs(attachments)),
 	}); err != nil {
 		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
 	}
@@ -215,7 +400,7 @@ func (h *AgentHandler) generateAppearanceDescription(ctx context.Context, agent
 	llmCtx, cancel := context.WithTimeout(ctx, llmRequestTimeout)
 	defer cancel()
 
-	prompt := buildAppearancePrompt(agent.Name, agent.Personality, agent.Gender)
+	prompt := compileAppearancePrompt(agent.Persona)
 	description, err := h.sendWriterPrompt(llmCtx, prompt)
 	if err != nil {
 		return "", fmt.Errorf("appearance prompt error: %w", err)
@@ -223,83 +408,239 @@ func (h *AgentHandler) generateAppearanceDescription(ctx context.Context, agent
 	return strings.TrimSpace(description), nil
 }
 
-func buildAppearancePrompt(name, personality, gender string) string {
-	return strings.TrimSpace(fmt.Sprintf(
-		`
-            You are crafting a short appearance description for a photorealistic portrait of %s.
-            The companion should feel like a real human. Describe their physical features, style, and outfit informed by this personality: %s, and gender identity: %s.
-            Focus on visual cues only in 1-2 sentences.
-        `,
-		name,
-		personality,
-		gender,
-	))
-}
-
-func buildSystemPrompt(name, personality, gender string) string {
-	return strings.TrimSpace(fmt.Sprintf(
-		`You are %s, a %s personality presenting as %s. Answer warmly and stay in character.`,
-		name,
-		personality,
-		gender,
-	))
-}
-
 func buildChatPrompt(systemPrompt, userPrompt string) string {
 	return strings.TrimSpace(fmt.Sprintf("%s\n\nUser: %s", systemPrompt, userPrompt))
 }
 
-func buildBaseImagePrompt(name, personality, gender, appearanceDescription string) string {
-	return strings.TrimSpace(fmt.Sprintf(
-		`
-            Create a front-facing, softly lit portrait of %s.
-            Appearance details: %s.
-            Personality cues: %s. 
-            Gender identity: %s.
-            Keep the pose relaxed, shoulders square, and expression gentle with a subtle smile so the image can be reused for future generations.
-        `,
-		name,
-		appearanceDescription,
-		personality,
-		gender,
-	))
-}
-
-func (h *AgentHandler) generateAndPersistBaseAppearance(ctx context.Context, agentID primitive.ObjectID) (string, error) {
+// generateAndPersistBaseAppearance generates the agent's portrait with opts. When several
+// candidates are requested the first becomes the provisional portrait and all of them
+// are recorded for the creator to choose from.
+func (h *AgentHandler) generateAndPersistBaseAppearance(ctx context.Context, agentID primitive.ObjectID, opts imagegen.Options) (ImageRenditions, []string, error) {
 	if h == nil {
-		return "", fmt.Errorf("handler not initialized")
-	}
-	if h.imageGen == nil || h.storage == nil {
-		return "", fmt.Errorf("image generation dependencies missing")
+		return ImageRenditions{}, nil, fmt.Errorf("handler not initialized")
 	}
-	collection := h.db.Client().Database(mongoDatabaseName()).Collection(agentsCollection)
+	collection := h.db.Database().Collection(agentsCollection)
 	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
 	defer dbCancel()
 	var stored Agent
 	if err := collection.FindOne(dbCtx, bson.M{"_id": agentID}).Decode(&stored); err != nil {
-		return "", fmt.Errorf("load agent for base image: %w", err)
+		return ImageRenditions{}, nil, fmt.Errorf("load agent for base image: %w", err)
 	}
-	prompt := buildBaseImagePrompt(stored.Name, stored.Personality, stored.Gender, stored.AppearanceDescription)
-	imageBytes, mimeType, err := h.imageGen.GenerateImage(ctx, prompt)
+	images, candidates, prompt, err := h.renderBaseAppearance(ctx, stored, opts)
 	if err != nil {
-		return "", err
+		return ImageRenditions{}, nil, err
 	}
-	objectName := fmt.Sprintf("%s-base", agentID.Hex())
-	uploadCtx, uploadCancel := context.WithTimeout(ctx, imageRequestTimeout)
-	defer uploadCancel()
-	uri, err := h.storage.UploadImage(uploadCtx, objectName, mimeType, imageBytes)
+	if err := h.recordBaseImage(ctx, agentID, images, imageSourceGenerated, prompt); err != nil {
+		return ImageRenditions{}, nil, err
+	}
+	if len(candidates) > 0 {
+		if err := h.recordPortraitCandidates(ctx, agentID, candidates); err != nil {
+			return ImageRenditions{}, nil, err
+		}
+	}
+	return images, candidates, nil
+}
+
+// renderBaseAppearance generates a's portrait and uploads its renditions, plus every
+// candidate when opts asks for several, without touching the agent document. It also
+// returns the prompt the portrait was generated from.
+func (h *AgentHandler) renderBaseAppearance(ctx context.Context, a Agent, opts imagegen.Options) (ImageRenditions, []string, string, error) {
+	if h.imageGen == nil || h.storage == nil {
+		return ImageRenditions{}, nil, "", fmt.Errorf("image generation dependencies missing")
+	}
+	prompt := compileImagePrompt(a.Persona, a.AppearanceDescription)
+	generated, err := h.imageGen.Generate(ctx, prompt, opts)
 	if err != nil {
-		return "", err
+		return ImageRenditions{}, nil, "", err
+	}
+	images, err := h.uploadBaseImage(ctx, a.ID, generated[0].Data)
+	if err != nil {
+		return ImageRenditions{}, nil, "", err
+	}
+	if len(generated) == 1 {
+		return images, nil, prompt, nil
+	}
+	candidates, err := h.uploadPortraitCandidates(ctx, a.ID, generated)
+	if err != nil {
+		h.releaseUploads(ctx, a.ID, []string{images.Thumb, images.Medium, images.Full})
+		return ImageRenditions{}, nil, "", err
+	}
+	return images, candidates, prompt, nil
+}
+
+// renderPlaceholderImage uploads a procedural avatar for a as its base image renditions.
+func (h *AgentHandler) renderPlaceholderImage(ctx context.Context, a Agent) (ImageRenditions, error) {
+	data, err := avatar.Render(a.ID.Hex(), a.Name, avatar.DefaultSize)
+	if err != nil {
+		return ImageRenditions{}, err
+	}
+	return h.uploadBaseImage(ctx, a.ID, data)
+}
+
+// persistBaseImage processes the agent's base portrait into renditions, uploads them and
+// records them on the agent document.
+func (h *AgentHandler) persistBaseImage(ctx context.Context, agentID primitive.ObjectID, imageBytes []byte, source, prompt string) (ImageRenditions, error) {
+	images, err := h.uploadBaseImage(ctx, agentID, imageBytes)
+	if err != nil {
+		return ImageRenditions{}, err
 	}
+	if err := h.recordBaseImage(ctx, agentID, images, source, prompt); err != nil {
+		return ImageRenditions{}, err
+	}
+	return images, nil
+}
+
+// uploadBaseImage processes a base portrait into renditions and uploads them. If one
+// upload fails, the ones already made are released.
+func (h *AgentHandler) uploadBaseImage(ctx context.Context, agentID primitive.ObjectID, imageBytes []byte) (ImageRenditions, error) {
+	outputs, err := imageproc.Process(imageBytes, imageproc.Options{})
+	if err != nil {
+		return ImageRenditions{}, fmt.Errorf("process base image: %w", err)
+	}
+	var images ImageRenditions
+	var uploaded []string
+	for _, out := range outputs {
+		uri, err := h.uploadAgentImage(ctx, agentID, out.MIMEType, out.Data)
+		if err != nil {
+			h.releaseUploads(ctx, agentID, uploaded)
+			return ImageRenditions{}, err
+		}
+		uploaded = append(uploaded, uri)
+		switch out.Name {
+		case "thumb":
+			images.Thumb = uri
+		case "medium":
+			images.Medium = uri
+		case "full":
+			images.Full = uri
+		}
+	}
+	return images, nil
+}
+
+// recordBaseImage points the agent document at uploaded base image renditions. The full
+// rendition is the reference for later generations and the medium one doubles as the
+// profile image. source is one of the imageSource constants; imageSourcePlaceholder flags
+// the agent for a later generation retry. Real portraits are also added to the agent's
+// public gallery.
+func (h *AgentHandler) recordBaseImage(ctx context.Context, agentID primitive.ObjectID, images ImageRenditions, source, prompt string) error {
+	_, err := h.replaceBaseImage(ctx, agentID, bson.M{"_id": agentID}, nil, images, source, prompt)
+	return err
+}
+
+// replaceBaseImage is recordBaseImage for the agent matching filter. archived, when set,
+// is pushed onto the image history in the same update, so the history cannot lose or
+// repeat an image. It reports whether filter matched.
+func (h *AgentHandler) replaceBaseImage(ctx context.Context, agentID primitive.ObjectID, filter bson.M, archived *ImageHistoryEntry, images ImageRenditions, source, prompt string) (bool, error) {
 	update := bson.M{
 		"$set": bson.M{
-			"base_appearance_referance_url": uri,
+			"base_appearance_reference_url": images.Full,
+			"profile_image_url":             images.Medium,
+			"images":                        images,
+			"placeholder_image":             source == imageSourcePlaceholder,
+			"image_source":                  source,
 		},
+		// A new base image settles any pending choice between generated candidates. The
+		// legacy field goes too, so Agent.UnmarshalBSON does not prefer a stale value.
+		"$unset": bson.M{"portrait_candidates": "", legacyBaseAppearanceField: ""},
+	}
+	if archived != nil {
+		update["$push"] = bson.M{"image_history": bson.M{
+			"$each":  []ImageHistoryEntry{*archived},
+			"$slice": -maxImageHistory,
+		}}
 	}
+	collection := h.db.Database().Collection(agentsCollection)
 	updateCtx, updateCancel := context.WithTimeout(ctx, dbRequestTimeout)
 	defer updateCancel()
-	if _, err := collection.UpdateByID(updateCtx, agentID, update); err != nil {
-		return "", fmt.Errorf("update agent with base image: %w", err)
+	result, err := collection.UpdateOne(updateCtx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("update agent with base image: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+	if source != imageSourcePlaceholder {
+		kind := photoKindBase
+		if source == imageSourceUploaded {
+			kind = photoKindUpload
+		}
+		h.addGalleryPhoto(ctx, GalleryPhoto{AgentID: agentID, Kind: kind, URL: images.Full, Prompt: prompt, Public: true})
+	}
+	return true, nil
+}
+
+// baseImageFilter matches agentID while its base image is still current, reading the
+// legacy field the way Agent.UnmarshalBSON does.
+func baseImageFilter(agentID primitive.ObjectID, current string) bson.M {
+	unset := bson.M{"$in": bson.A{"", nil}}
+	if current == "" {
+		return bson.M{"_id": agentID, "base_appearance_reference_url": unset, legacyBaseAppearanceField: unset}
+	}
+	return bson.M{"_id": agentID, "$or": bson.A{
+		bson.M{legacyBaseAppearanceField: current},
+		bson.M{"base_appearance_reference_url": current, legacyBaseAppearanceField: unset},
+	}}
+}
+
+// uploadAgentImage stores an image belonging to an agent under its content hash, records
+// the agent as one of the blob's owners and returns its URL.
+func (h *AgentHandler) uploadAgentImage(ctx context.Context, agentID primitive.ObjectID, mimeType string, data []byte) (string, error) {
+	if h == nil || h.storage == nil {
+		return "", fmt.Errorf("storage service not initialized")
+	}
+	uploadCtx, uploadCancel := context.WithTimeout(ctx, imageRequestTimeout)
+	defer uploadCancel()
+	uri, err := h.storage.UploadImage(uploadCtx, storage.ContentKey(mimeType, data), mimeType, data)
+	if err != nil {
+		return "", err
+	}
+	// An object stored without its record is left to CollectGarbage.
+	if err := h.recordBlob(ctx, agentID, uri, mimeType, len(data)); err != nil {
+		return "", err
 	}
 	return uri, nil
 }
+
+// uploadProcessedImage re-encodes a generated image at full size before storing it, so
+// scene and chat pictures get the same clean-up as base portraits.
+func (h *AgentHandler) uploadProcessedImage(ctx context.Context, agentID primitive.ObjectID, data []byte) (string, string, error) {
+	outputs, err := imageproc.Process(data, imageproc.Options{Renditions: imageproc.DefaultRenditions[len(imageproc.DefaultRenditions)-1:]})
+	if err != nil {
+		return "", "", fmt.Errorf("process image: %w", err)
+	}
+	out := outputs[0]
+	uri, err := h.uploadAgentImage(ctx, agentID, out.MIMEType, out.Data)
+	if err != nil {
+		return "", "", err
+	}
+	return uri, out.MIMEType, nil
+}
+
+// loadAgent fetches an agent by id, reporting a missing document as a 404 requestError.
+func (h *AgentHandler) loadAgent(ctx context.Context, agentID primitive.ObjectID) (Agent, error) {
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	collection := h.db.Database().Collection(agentsCollection)
+	var stored Agent
+	if err := collection.FindOne(dbCtx, bson.M{"_id": agentID}).Decode(&stored); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Agent{}, newRequestError(http.StatusNotFound, "agent not found")
+		}
+		return Agent{}, newRequestError(http.StatusInternalServerError, "failed to load agent: %v", err)
+	}
+	return stored, nil
+}
+
+// loadOwnedAgent fetches an agent created by ownerID. Agents owned by someone else are
+// reported as missing so their existence is not leaked.
+func (h *AgentHandler) loadOwnedAgent(ctx context.Context, agentID, ownerID primitive.ObjectID) (Agent, error) {
+	stored, err := h.loadAgent(ctx, agentID)
+	if err != nil {
+		return Agent{}, err
+	}
+	if stored.CreatedBy != ownerID {
+		return Agent{}, newRequestError(http.StatusNotFound, "agent not found")
+	}
+	return stored, nil
+}
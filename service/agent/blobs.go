@@ -0,0 +1,316 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"buddy-agent/service/dbservice"
+	"buddy-agent/service/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultGCGrace is how old an unreferenced object must be before CollectGarbage deletes it.
+const DefaultGCGrace = 24 * time.Hour
+
+// blobReferences lists every document field that holds a stored image URL. Objects not
+// referenced from one of these fields are garbage once they are older than the grace
+// period. Dotted paths descend into sub-documents and arrays.
+var blobReferences = []struct {
+	collection string
+	fields     []string
+}{
+	{agentsCollection, []string{
+		"profile_image_url",
+		"base_appearance_reference_url",
+		// Replicas of the previous release still write the misspelled field.
+		legacyBaseAppearanceField,
+		"images.thumb",
+		"images.medium",
+		"images.full",
+		"image_history.url",
+		"portrait_candidates",
+	}},
+	{galleryCollection, []string{"url"}},
+	{chatMessagesCollection, []string{"attachments", "image_urls"}},
+	{socialProfileCollection, []string{"profile_url"}},
+}
+
+// GCOptions controls CollectGarbage.
+type GCOptions struct {
+	// Grace protects objects younger than this, which may belong to an upload whose
+	// document has not been written yet. Zero means DefaultGCGrace.
+	Grace time.Duration
+	// DryRun reports what would be deleted without deleting anything.
+	DryRun bool
+}
+
+// GCReport summarises a CollectGarbage run.
+type GCReport struct {
+	Scanned    int
+	Referenced int
+	// Recent counts unreferenced objects kept because they are inside the grace period.
+	Recent int
+	// Deleted lists the keys removed, or the ones that would be removed in a dry run.
+	Deleted      []string
+	DeletedBytes int64
+}
+
+// recordBlob notes that agentID uses the object behind uri. Chat attachments may only be
+// referenced again by the agent that owns them, so a failure here fails the upload.
+func (h *AgentHandler) recordBlob(ctx context.Context, agentID primitive.ObjectID, uri, mimeType string, size int) error {
+	key, err := h.storage.Key(uri)
+	if err != nil {
+		return fmt.Errorf("record blob %q: %w", uri, err)
+	}
+	collection := h.db.Database().Collection(blobsCollection)
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	now := time.Now().UTC()
+	update := bson.M{
+		"$setOnInsert": bson.M{"url": uri, "content_type": mimeType, "size": size, "created_at": now},
+		"$set":         bson.M{"uploaded_at": now},
+		"$addToSet":    bson.M{"agent_ids": agentID},
+	}
+	if _, err := collection.UpdateByID(dbCtx, key, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("record blob %s: %w", key, err)
+	}
+	return nil
+}
+
+// storedURL returns the form of uri that documents store, undoing ResolveURL.
+func (h *AgentHandler) storedURL(uri string) (string, error) {
+	key, err := h.storage.Key(uri)
+	if err != nil {
+		return "", err
+	}
+	return h.storage.URL(key)
+}
+
+// agentOwnsBlob reports whether the object behind uri was uploaded for agentID. Chat
+// attachments uploaded before blobs were recorded have no record, so a chat message of
+// the agent that carries uri, in its stored form, also counts.
+func (h *AgentHandler) agentOwnsBlob(ctx context.Context, agentID primitive.ObjectID, uri string) (bool, error) {
+	key, err := h.storage.Key(uri)
+	if err != nil {
+		return false, nil
+	}
+	db := h.db.Database()
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	err = db.Collection(blobsCollection).FindOne(dbCtx, bson.M{"_id": key, "agent_ids": agentID}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = db.Collection(chatMessagesCollection).FindOne(dbCtx, bson.M{"agent_id": agentID, "attachments": uri}).Err()
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// releaseBlobs undoes uploads made for agentID whose documents were never stored by
+// dropping the agent from each blob's owners. The objects themselves are left to
+// CollectGarbage: another agent may be uploading the same bytes right now, between its
+// UploadImage and recordBlob, and only the garbage collector's grace period covers that.
+func (h *AgentHandler) releaseBlobs(ctx context.Context, agentID primitive.ObjectID, uris []string) error {
+	keys := h.blobKeys(uris)
+	if len(keys) == 0 {
+		return nil
+	}
+	collection := h.db.Database().Collection(blobsCollection)
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	if _, err := collection.UpdateMany(dbCtx, bson.M{"_id": bson.M{"$in": keys}}, bson.M{"$pull": bson.M{"agent_ids": agentID}}); err != nil {
+		return fmt.Errorf("release blobs: %w", err)
+	}
+	return nil
+}
+
+// blobKeys returns the distinct object keys behind uris, skipping empty URLs and URLs
+// that do not belong to the configured store.
+func (h *AgentHandler) blobKeys(uris []string) []string {
+	var keys []string
+	for _, uri := range uris {
+		if uri == "" {
+			continue
+		}
+		if key, err := h.storage.Key(uri); err == nil && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// releaseUploads is releaseBlobs for cleanup paths that already report another error.
+func (h *AgentHandler) releaseUploads(ctx context.Context, agentID primitive.ObjectID, uris []string) {
+	if err := h.releaseBlobs(context.WithoutCancel(ctx), agentID, uris); err != nil {
+		log.Printf("release uploads of %s failed: %v", agentID.Hex(), err)
+	}
+}
+
+// CollectGarbage deletes stored objects that no document references and that are older
+// than the grace period, such as images of deleted agents, of creations that failed
+// halfway, and portraits that were replaced and dropped from the history. It refuses to
+// run while agent migrations are pending, because blobReferences only knows the fields
+// of the current schema.
+func (h *AgentHandler) CollectGarbage(ctx context.Context, opts GCOptions) (GCReport, error) {
+	var report GCReport
+	if h == nil || h.storage == nil {
+		return report, fmt.Errorf("storage service not initialized")
+	}
+	pending, err := h.db.Migrate(ctx, Migrations(), dbservice.MigrateOptions{DryRun: true})
+	if err != nil {
+		return report, fmt.Errorf("check migrations: %w", err)
+	}
+	if n := len(pending.Pending); n > 0 {
+		return report, fmt.Errorf("%d schema migrations are pending; run --migrate first", n)
+	}
+	grace := opts.Grace
+	if grace <= 0 {
+		grace = DefaultGCGrace
+	}
+	// Take the cutoff before marking, so anything uploaded during the scan is protected.
+	cutoff := time.Now().Add(-grace)
+	referenced, err := h.referencedBlobKeys(ctx)
+	if err != nil {
+		return report, err
+	}
+	return h.sweep(ctx, referenced, cutoff, opts.DryRun, mongoBlobs{h.db.Database().Collection(blobsCollection)})
+}
+
+// blobRecords is the part of the blobs registry the sweep needs.
+type blobRecords interface {
+	// uploadedAt returns when key was last uploaded, or the zero time without a record.
+	uploadedAt(ctx context.Context, key string) (time.Time, error)
+	forget(ctx context.Context, key string) error
+}
+
+type mongoBlobs struct{ collection *mongo.Collection }
+
+func (b mongoBlobs) uploadedAt(ctx context.Context, key string) (time.Time, error) {
+	var blob Blob
+	err := b.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&blob)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("load blob %s: %w", key, err)
+	}
+	return blob.UploadedAt, nil
+}
+
+func (b mongoBlobs) forget(ctx context.Context, key string) error {
+	if _, err := b.collection.DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		return fmt.Errorf("delete blob %s: %w", key, err)
+	}
+	return nil
+}
+
+// sweep deletes every stored object that is neither referenced nor written or uploaded
+// after cutoff.
+func (h *AgentHandler) sweep(ctx context.Context, referenced map[string]bool, cutoff time.Time, dryRun bool, blobs blobRecords) (GCReport, error) {
+	var report GCReport
+	err := h.storage.List(ctx, func(obj storage.ObjectInfo) error {
+		report.Scanned++
+		if referenced[obj.Key] {
+			report.Referenced++
+			return nil
+		}
+		if obj.Modified.After(cutoff) {
+			report.Recent++
+			return nil
+		}
+		// The same bytes may have been uploaded again since the object was written.
+		uploaded, err := blobs.uploadedAt(ctx, obj.Key)
+		if err != nil {
+			return err
+		}
+		if uploaded.After(cutoff) {
+			report.Recent++
+			return nil
+		}
+		report.Deleted = append(report.Deleted, obj.Key)
+		report.DeletedBytes += obj.Size
+		if dryRun {
+			return nil
+		}
+		if err := h.storage.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+		return blobs.forget(ctx, obj.Key)
+	})
+	return report, err
+}
+
+// referencedBlobKeys collects the object keys of every image URL in blobReferences.
+// URLs that do not belong to the configured store are ignored.
+func (h *AgentHandler) referencedBlobKeys(ctx context.Context) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	db := h.db.Database()
+	for _, ref := range blobReferences {
+		projection := bson.D{}
+		for _, field := range ref.fields {
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+		cursor, err := db.Collection(ref.collection).Find(ctx, bson.M{}, options.Find().SetProjection(projection))
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", ref.collection, err)
+		}
+		for cursor.Next(ctx) {
+			var doc bson.M
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ctx)
+				return nil, fmt.Errorf("decode %s document: %w", ref.collection, err)
+			}
+			h.markReferences(keys, doc, ref.fields)
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", ref.collection, err)
+		}
+	}
+	return keys, nil
+}
+
+// markReferences adds the object key of every image URL at fields of doc to keys.
+func (h *AgentHandler) markReferences(keys map[string]bool, doc bson.M, fields []string) {
+	for _, field := range fields {
+		collectStrings(doc, strings.Split(field, "."), func(uri string) {
+			if key, err := h.storage.Key(uri); err == nil {
+				keys[key] = true
+			}
+		})
+	}
+}
+
+// collectStrings calls fn for every non-empty string found at path below v.
+func collectStrings(v any, path []string, fn func(string)) {
+	switch t := v.(type) {
+	case string:
+		if len(path) == 0 && t != "" {
+			fn(t)
+		}
+	case bson.M:
+		if len(path) > 0 {
+			collectStrings(t[path[0]], path[1:], fn)
+		}
+	case bson.D:
+		for _, e := range t {
+			if len(path) > 0 && e.Key == path[0] {
+				collectStrings(e.Value, path[1:], fn)
+			}
+		}
+	case bson.A:
+		for _, item := range t {
+			collectStrings(item, path, fn)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"buddy-agent/service/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCollectStrings(t *testing.T) {
+	doc := bson.M{
+		"profile_image_url":   "a",
+		"images":              bson.D{{Key: "thumb", Value: "b"}, {Key: "full", Value: ""}},
+		"image_history":       bson.A{bson.M{"url": "c"}, bson.M{"source": "uploaded"}},
+		"portrait_candidates": bson.A{"d", "e"},
+	}
+	var got []string
+	for _, field := range []string{"profile_image_url", "images.thumb", "images.full", "image_history.url", "portrait_candidates", "missing"} {
+		collectStrings(doc, strings.Split(field, "."), func(s string) { got = append(got, s) })
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !slices.Equal(got, want) {
+		t.Fatalf("collected %q, want %q", got, want)
+	}
+}
+
+func TestBlobKeysSkipsForeignAndDuplicateURLs(t *testing.T) {
+	h := &AgentHandler{storage: storage.NewMemory(storage.Config{BaseURL: "http://localhost:3000/api/v1/media"})}
+	uris := []string{
+		"http://localhost:3000/api/v1/media/base-faces/a.png",
+		"",
+		"https://elsewhere.example/base-faces/b.png",
+		"http://localhost:3000/api/v1/media/base-faces/a.png",
+		"http://localhost:3000/api/v1/media/base-faces/c.jpg",
+	}
+	if got, want := h.blobKeys(uris), []string{"a.png", "c.jpg"}; !slices.Equal(got, want) {
+		t.Fatalf("blobKeys = %q, want %q", got, want)
+	}
+}
+
+type fakeBlobs map[string]time.Time
+
+func (f fakeBlobs) uploadedAt(_ context.Context, key string) (time.Time, error) { return f[key], nil }
+
+func (f fakeBlobs) forget(_ context.Context, key string) error {
+	delete(f, key)
+	return nil
+}
+
+func TestSweepKeepsBlobReferencedByLegacyField(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory(storage.Config{BaseURL: "http://localhost:3000/api/v1/media"})
+	h := &AgentHandler{storage: store}
+	legacyURL, err := store.UploadImage(ctx, "base-faces/legacy", "image/png", []byte("legacy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphanURL, err := store.UploadImage(ctx, "base-faces/orphan", "image/png", []byte("orphan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	referenced := make(map[string]bool)
+	for _, ref := range blobReferences {
+		if ref.collection == agentsCollection {
+			h.markReferences(referenced, bson.M{legacyBaseAppearanceField: legacyURL}, ref.fields)
+		}
+	}
+	// A cutoff in the future puts every object outside the grace period.
+	report, err := h.sweep(ctx, referenced, time.Now().Add(time.Hour), false, fakeBlobs{})
+	if err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	orphanKey, _ := store.Key(orphanURL)
+	if !slices.Equal(report.Deleted, []string{orphanKey}) {
+		t.Fatalf("deleted %q, want only %q", report.Deleted, orphanKey)
+	}
+	if _, _, err := store.ReadImage(ctx, legacyURL); err != nil {
+		t.Fatalf("blob referenced by %s was deleted: %v", legacyBaseAppearanceField, err)
+	}
+}
+
+func TestStoredURL(t *testing.T) {
+	store := storage.NewMemory(storage.Config{Prefix: "base-faces", BaseURL: "http://localhost:3000/api/v1/media"})
+	h := &AgentHandler{storage: store}
+	uri, err := store.UploadImage(context.Background(), "a", "image/png", []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := h.storedURL(uri); err != nil || got != uri {
+		t.Fatalf("storedURL(%q) = %q, %v", uri, got, err)
+	}
+	if _, err := h.storedURL("https://elsewhere.example/base-faces/a.png"); err == nil {
+		t.Fatal("expected an error for a foreign url")
+	}
+}
@@ -0,0 +1,254 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"buddy-agent/service/charcard"
+)
+
+const (
+	// cardExtensionName namespaces our lossless persona copy inside card extensions.
+	cardExtensionName  = "buddy_agent"
+	maxCardUploadBytes = 10 << 20
+	unspecifiedGender  = "unspecified"
+)
+
+type cardExtension struct {
+	Persona               Persona `json:"persona"`
+	AppearanceDescription string  `json:"appearance_description,omitempty"`
+}
+
+// ExportAgentCard returns an agent as a Character Card V2, either as JSON or as the base
+// portrait PNG with the card embedded (format=png).
+func (h *AgentHandler) ExportAgentCard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "png" {
+		respondJSONError(w, http.StatusBadRequest, "format must be json or png")
+		return
+	}
+
+	stored, err := h.loadOwnedAgent(r.Context(), agentID, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	card, err := agentToCard(stored)
+	if err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to build card: %v", err))
+		return
+	}
+	filename := sanitizeUsername(stored.Name)
+	if filename == "" {
+		filename = stored.ID.Hex()
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		if err := json.NewEncoder(w).Encode(card); err != nil {
+			respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
+		}
+		return
+	}
+
+	if stored.BaseAppearanceReferenceURL == "" {
+		respondJSONError(w, http.StatusConflict, "agent has no base image to embed the card in")
+		return
+	}
+	imageCtx, imageCancel := context.WithTimeout(r.Context(), imageRequestTimeout)
+	defer imageCancel()
+	imageBytes, _, err := h.storage.ReadImage(imageCtx, stored.BaseAppearanceReferenceURL)
+	if err != nil {
+		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to load base image: %v", err))
+		return
+	}
+	pngBytes, err := ensurePNG(imageBytes)
+	if err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to convert base image: %v", err))
+		return
+	}
+	out, err := charcard.EmbedPNG(pngBytes, card)
+	if err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to embed card: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".png"))
+	_, _ = w.Write(out)
+}
+
+// ImportAgentCard creates a new agent from a Character Card V2 sent either as JSON or as a
+// PNG with embedded card metadata. PNG card art is re-uploaded as the base portrait.
+func (h *AgentHandler) ImportAgentCard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	creator, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCardUploadBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("card must be at most %d bytes", maxCardUploadBytes))
+			return
+		}
+		respondJSONError(w, http.StatusBadRequest, "failed to read card")
+		return
+	}
+
+	spec := newAgentSpec{}
+	var card charcard.Card
+	if charcard.IsPNG(body) {
+		card, err = charcard.FromPNG(body)
+		spec.BaseImage = body
+	} else {
+		card, err = charcard.Parse(body)
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, charcard.ErrNoCard) {
+			status = http.StatusUnprocessableEntity
+		}
+		respondJSONError(w, status, err.Error())
+		return
+	}
+	spec.Persona, spec.AppearanceDescription, err = personaFromCard(card)
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := h.createAgent(r.Context(), creator, spec)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	h.respondCreatedAgent(w, r, created)
+}
+
+func agentToCard(a Agent) (charcard.Card, error) {
+	description := a.Backstory
+	if a.AppearanceDescription != "" {
+		description = strings.TrimSpace(description + "\n\nAppearance: " + a.AppearanceDescription)
+	}
+	scenario := ""
+	if a.Relationship != "" {
+		scenario = fmt.Sprintf("{{char}} is {{user}}'s %s.", a.Relationship)
+	}
+	examples := make([]string, 0, len(a.Catchphrases))
+	for _, phrase := range a.Catchphrases {
+		examples = append(examples, "<START>\n{{char}}: "+phrase)
+	}
+	card := charcard.New(charcard.Data{
+		Name:             a.Name,
+		Description:      description,
+		Personality:      a.Personality,
+		Scenario:         scenario,
+		MesExample:       strings.Join(examples, "\n"),
+		SystemPrompt:     a.SystemPrompt,
+		Tags:             a.Interests,
+		CharacterVersion: strconv.Itoa(max(a.Version, 1)),
+	})
+	err := card.SetExtension(cardExtensionName, cardExtension{
+		Persona:               a.Persona,
+		AppearanceDescription: a.AppearanceDescription,
+	})
+	return card, err
+}
+
+// personaFromCard prefers our own extension for a lossless round trip and otherwise maps
+// the generic card fields onto a persona.
+func personaFromCard(card charcard.Card) (Persona, string, error) {
+	var ext cardExtension
+	found, err := card.Extension(cardExtensionName, &ext)
+	if err != nil {
+		return Persona{}, "", err
+	}
+	if found {
+		return ext.Persona, ext.AppearanceDescription, nil
+	}
+
+	data := card.Data
+	persona := Persona{
+		Name:        truncateRunes(strings.TrimSpace(data.Name), maxNameLength),
+		Personality: strings.TrimSpace(data.Personality),
+		Gender:      unspecifiedGender,
+		Backstory:   truncateRunes(strings.TrimSpace(data.Description), maxBackstoryLength),
+		Interests:   data.Tags,
+	}
+	if persona.Personality == "" {
+		persona.Personality = firstSentence(data.Description)
+	}
+	persona.Personality = truncateRunes(persona.Personality, maxPersonalityLength)
+	if persona.Personality == "" {
+		return Persona{}, "", fmt.Errorf("character card needs a personality or description")
+	}
+	persona.normalize()
+	clampPersona(&persona)
+	for i, interest := range persona.Interests {
+		persona.Interests[i] = truncateRunes(interest, maxInterestLength)
+	}
+	return persona, "", nil
+}
+
+// ensurePNG re-encodes non-PNG images so card metadata can be embedded in them.
+func ensurePNG(data []byte) ([]byte, error) {
+	if charcard.IsPNG(data) {
+		return data, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func firstSentence(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexAny(text, ".!?\n"); i >= 0 {
+		return strings.TrimSpace(text[:i+1])
+	}
+	return text
+}
+
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) > limit {
+		return strings.TrimSpace(string(runes[:limit]))
+	}
+	return text
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type cloneRequest struct {
+	// Name renames the clone; the source name is kept when empty.
+	Name string `json:"name"`
+	// IncludeAppearance copies the source's appearance description and base portrait
+	// instead of generating a fresh look.
+	IncludeAppearance bool `json:"include_appearance"`
+}
+
+// CloneAgent copies another agent's persona into a new agent owned by the caller and
+// records the source as forked_from. The clone gets its own social profile.
+func (h *AgentHandler) CloneAgent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	sourceID, err := primitive.ObjectIDFromHex(strings.TrimSpace(r.PathValue("id")))
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, "invalid agent id")
+		return
+	}
+
+	var req cloneRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+
+	source, err := h.loadAgent(r.Context(), sourceID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	spec := newAgentSpec{Persona: source.Persona, ForkedFrom: &source.ID}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		spec.Persona.Name = name
+	}
+	if req.IncludeAppearance {
+		spec.AppearanceDescription = source.AppearanceDescription
+		if source.BaseAppearanceReferenceURL != "" {
+			imageCtx, imageCancel := context.WithTimeout(r.Context(), imageRequestTimeout)
+			defer imageCancel()
+			// Copy the portrait so the clone keeps it even if the source agent is removed.
+			spec.BaseImageSource = imageSourceCloned
+			spec.BaseImage, _, err = h.storage.ReadImage(imageCtx, source.BaseAppearanceReferenceURL)
+			if err != nil {
+				respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to copy base image: %v", err))
+				return
+			}
+		}
+	}
+
+	created, err := h.createAgent(r.Context(), requester, spec)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	h.respondCreatedAgent(w, r, created)
+}
@@ -0,0 +1,249 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Kinds of gallery photos.
+const (
+	photoKindBase   = "base"
+	photoKindScene  = "scene"
+	photoKindSelfie = "selfie"
+	photoKindUpload = "upload"
+)
+
+const (
+	maxCaptionLength = 300
+	maxProfilePhotos = 24
+)
+
+type galleryPhotoUpdate struct {
+	Caption  *string `json:"caption"`
+	Public   *bool   `json:"public"`
+	Position *int    `json:"position"`
+}
+
+// ListGalleryPhotos returns an agent's gallery in display order. The creator sees every
+// photo; other users only see the public ones.
+func (h *AgentHandler) ListGalleryPhotos(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	stored, err := h.loadAgent(r.Context(), agentID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	filter := bson.M{"agent_id": agentID}
+	if stored.CreatedBy != requester.ID {
+		filter["public"] = true
+	}
+	photos, err := h.findGalleryPhotos(r.Context(), filter)
+	if err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to load gallery: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"agent_id": agentID, "photos": h.presentPhotos(r.Context(), photos)}); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
+	}
+}
+
+// GalleryPhoto edits (PATCH) or removes (DELETE) a single gallery photo owned by the caller.
+func (h *AgentHandler) GalleryPhoto(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPatch, http.MethodDelete:
+	default:
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	photoID, err := primitive.ObjectIDFromHex(strings.TrimSpace(r.URL.Query().Get("photoId")))
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, "invalid photoId")
+		return
+	}
+	photo, err := h.loadGalleryPhoto(r.Context(), photoID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	stored, err := h.loadOwnedAgent(r.Context(), photo.AgentID, requester.ID)
+	if err != nil {
+		// Hide photos of other users' agents behind the same 404 as missing ones.
+		respondJSONError(w, http.StatusNotFound, "photo not found")
+		return
+	}
+
+	collection := h.db.Database().Collection(galleryCollection)
+	dbCtx, dbCancel := context.WithTimeout(r.Context(), dbRequestTimeout)
+	defer dbCancel()
+	if r.Method == http.MethodDelete {
+		if photo.URL == stored.BaseAppearanceReferenceURL {
+			respondJSONError(w, http.StatusConflict, "cannot delete the agent's current portrait")
+			return
+		}
+		if _, err := collection.DeleteOne(dbCtx, bson.M{"_id": photoID}); err != nil {
+			respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to delete photo: %v", err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req galleryPhotoUpdate
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	set := bson.M{}
+	if req.Caption != nil {
+		caption := strings.TrimSpace(*req.Caption)
+		if err := checkLength("caption", caption, maxCaptionLength); err != nil {
+			respondJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		set["caption"] = caption
+		photo.Caption = caption
+	}
+	if req.Public != nil {
+		set["public"] = *req.Public
+		photo.Public = *req.Public
+	}
+	if req.Position != nil {
+		if *req.Position < 0 {
+			respondJSONError(w, http.StatusBadRequest, "position must not be negative")
+			return
+		}
+		set["position"] = *req.Position
+		photo.Position = *req.Position
+	}
+	if len(set) == 0 {
+		respondJSONError(w, http.StatusBadRequest, "nothing to update")
+		return
+	}
+	if _, err := collection.UpdateByID(dbCtx, photoID, bson.M{"$set": set}); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to update photo: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	photo.URL = h.resolveURL(r.Context(), photo.URL)
+	_ = json.NewEncoder(w).Encode(photo)
+}
+
+// addGalleryPhoto appends a photo to the end of the agent's gallery. Failures are logged
+// rather than returned: the image itself is already stored and in use.
+func (h *AgentHandler) addGalleryPhoto(ctx context.Context, photo GalleryPhoto) {
+	collection := h.db.Database().Collection(galleryCollection)
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+
+	var last GalleryPhoto
+	opts := options.FindOne().SetSort(bson.D{{Key: "position", Value: -1}})
+	err := collection.FindOne(dbCtx, bson.M{"agent_id": photo.AgentID}, opts).Decode(&last)
+	switch {
+	case err == nil:
+		photo.Position = last.Position + 1
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		log.Printf("gallery position for %s failed: %v", photo.AgentID.Hex(), err)
+	}
+	photo.ID = primitive.NewObjectID()
+	photo.CreatedAt = time.Now().UTC()
+	if _, err := collection.InsertOne(dbCtx, photo); err != nil {
+		log.Printf("add %s photo to gallery of %s failed: %v", photo.Kind, photo.AgentID.Hex(), err)
+	}
+}
+
+// removeGalleryPhotos deletes the agent's gallery entries for a storage URL.
+func (h *AgentHandler) removeGalleryPhotos(ctx context.Context, agentID primitive.ObjectID, url string) {
+	collection := h.db.Database().Collection(galleryCollection)
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	if _, err := collection.DeleteMany(dbCtx, bson.M{"agent_id": agentID, "url": url}); err != nil {
+		log.Printf("remove gallery photos of %s failed: %v", agentID.Hex(), err)
+	}
+}
+
+func (h *AgentHandler) loadGalleryPhoto(ctx context.Context, photoID primitive.ObjectID) (GalleryPhoto, error) {
+	collection := h.db.Database().Collection(galleryCollection)
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	var photo GalleryPhoto
+	if err := collection.FindOne(dbCtx, bson.M{"_id": photoID}).Decode(&photo); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return GalleryPhoto{}, newRequestError(http.StatusNotFound, "photo not found")
+		}
+		return GalleryPhoto{}, newRequestError(http.StatusInternalServerError, "failed to load photo: %v", err)
+	}
+	return photo, nil
+}
+
+func (h *AgentHandler) findGalleryPhotos(ctx context.Context, filter bson.M) ([]GalleryPhoto, error) {
+	collection := h.db.Database().Collection(galleryCollection)
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "created_at", Value: 1}})
+	cursor, err := collection.Find(dbCtx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(dbCtx)
+	photos := make([]GalleryPhoto, 0)
+	if err := cursor.All(dbCtx, &photos); err != nil {
+		return nil, err
+	}
+	return photos, nil
+}
+
+// attachPublicPhotos fills each profile's photo grid with its agent's public gallery.
+func (h *AgentHandler) attachPublicPhotos(ctx context.Context, profiles []AgentSocialProfile) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+	agentIDs := make([]primitive.ObjectID, 0, len(profiles))
+	for _, p := range profiles {
+		agentIDs = append(agentIDs, p.AgentID)
+	}
+	photos, err := h.findGalleryPhotos(ctx, bson.M{"agent_id": bson.M{"$in": agentIDs}, "public": true})
+	if err != nil {
+		return err
+	}
+	byAgent := make(map[primitive.ObjectID][]GalleryPhoto, len(profiles))
+	for _, photo := range photos {
+		if len(byAgent[photo.AgentID]) < maxProfilePhotos {
+			byAgent[photo.AgentID] = append(byAgent[photo.AgentID], photo)
+		}
+	}
+	for i := range profiles {
+		profiles[i].Photos = byAgent[profiles[i].AgentID]
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"context"
+
+	"buddy-agent/service/dbservice"
+	"buddy-agent/service/jobs"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Indexes lists the indexes of the collections the agent handler owns, shaped after the
+// queries it runs.
+func Indexes() []dbservice.CollectionIndexes {
+	return []dbservice.CollectionIndexes{
+		{Collection: agentsCollection, Indexes: []dbservice.Index{
+			{Name: "created_by", Keys: bson.D{{Key: "created_by", Value: 1}}},
+		}},
+		{Collection: socialProfileCollection, Indexes: []dbservice.Index{
+			{Name: "agent_id_unique", Keys: bson.D{{Key: "agent_id", Value: 1}}, Unique: true},
+			{Name: "username_unique", Keys: bson.D{{Key: "username", Value: 1}}, Unique: true},
+			{Name: "created_by", Keys: bson.D{{Key: "created_by", Value: 1}}},
+		}},
+		{Collection: versionsCollection, Indexes: []dbservice.Index{
+			{Name: "agent_id_version_unique", Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "version", Value: -1}}, Unique: true},
+		}},
+		{Collection: galleryCollection, Indexes: []dbservice.Index{
+			{Name: "agent_id_position", Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "position", Value: 1}, {Key: "created_at", Value: 1}}},
+		}},
+		{Collection: chatMessagesCollection, Indexes: []dbservice.Index{
+			{Name: "agent_id_created_at", Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		}},
+		{Collection: jobsCollection, Indexes: jobs.Indexes()},
+	}
+}
+
+// EnsureIndexes reconciles the agent collections with Indexes.
+func (h *AgentHandler) EnsureIndexes(ctx context.Context, opts dbservice.IndexOptions) (dbservice.IndexReport, error) {
+	return h.db.EnsureIndexes(ctx, Indexes(), opts)
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"buddy-agent/service/imagegen"
+	"buddy-agent/service/jobs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Background job kinds processed by the agent handlers.
+const (
+	jobSocialProfile = "social_profile"
+	jobBaseImage     = "base_image"
+)
+
+// NewJobWorker builds a worker that processes the agent background jobs.
+func (h *AgentHandler) NewJobWorker(cfg jobs.WorkerConfig) *jobs.Worker {
+	worker := jobs.NewWorker(h.jobs, cfg)
+	worker.Handle(jobSocialProfile, h.runSocialProfileJob)
+	worker.Handle(jobBaseImage, h.runBaseImageJob)
+	return worker
+}
+
+// enqueueAgentJob schedules a job for agentID to run once delay has passed.
+func (h *AgentHandler) enqueueAgentJob(kind string, agentID primitive.ObjectID, delay time.Duration) {
+	if h == nil || h.jobs == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbRequestTimeout)
+	defer cancel()
+	job := jobs.Job{Kind: kind, AgentID: agentID}
+	if delay > 0 {
+		job.RunAfter = time.Now().UTC().Add(delay)
+	}
+	if _, err := h.jobs.Enqueue(ctx, job); err != nil {
+		log.Printf("enqueue %s job for %s failed: %v", kind, agentID.Hex(), err)
+	}
+}
+
+func (h *AgentHandler) runSocialProfileJob(ctx context.Context, job jobs.Job) error {
+	ctx, cancel := context.WithTimeout(ctx, socialProfileJobTimeout)
+	defer cancel()
+	return h.generateAndPersistSocialProfile(ctx, job.AgentID)
+}
+
+// runBaseImageJob generates the portrait for an agent still showing a placeholder. Agents
+// that already have a real image, for example one uploaded meanwhile, are left alone.
+func (h *AgentHandler) runBaseImageJob(ctx context.Context, job jobs.Job) error {
+	stored, err := h.loadAgent(ctx, job.AgentID)
+	if err != nil {
+		return err
+	}
+	if !stored.PlaceholderImage && stored.BaseAppearanceReferenceURL != "" {
+		return nil
+	}
+	_, _, err = h.generateAndPersistBaseAppearance(ctx, job.AgentID, imagegen.Options{})
+	return err
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"buddy-agent/service/storage"
+)
+
+// MediaHandler serves stored images when the storage backend keeps them locally or proxies
+// a private bucket, and is nil when objects are served by the storage provider instead.
+// Private media is only served to signed-in users, or to requests carrying the signature
+// the store added when it resolved the URL, which is what a browser <img src> sends.
+func (h *AgentHandler) MediaHandler() http.Handler {
+	server, ok := h.storage.(storage.MediaServer)
+	if !ok {
+		return nil
+	}
+	handler := server.MediaHandler()
+	if handler == nil || !server.PrivateMedia() {
+		return handler
+	}
+	verifier, _ := server.(storage.MediaVerifier)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if verifier != nil && verifier.VerifyMediaRequest(r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := h.requireUser(w, r); !ok {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// resolveURL turns a stored image URI into one the client can fetch. With a private
+// bucket this is a presigned or proxied URL, so it must be computed on every read and
+// never persisted. Failures are logged and yield an empty URL.
+func (h *AgentHandler) resolveURL(ctx context.Context, uri string) string {
+	if uri == "" || h == nil || h.storage == nil {
+		return uri
+	}
+	resolved, err := h.storage.ResolveURL(ctx, uri)
+	if err != nil {
+		log.Printf("resolve image url %q failed: %v", uri, err)
+		return ""
+	}
+	return resolved
+}
+
+func (h *AgentHandler) resolveURLs(ctx context.Context, uris []string) []string {
+	if len(uris) == 0 {
+		return uris
+	}
+	resolved := make([]string, len(uris))
+	for i, uri := range uris {
+		resolved[i] = h.resolveURL(ctx, uri)
+	}
+	return resolved
+}
+
+// presentAgent builds the public view of an agent with fetchable image URLs.
+func (h *AgentHandler) presentAgent(ctx context.Context, a Agent) agentListItem {
+	item := newAgentListItem(a)
+	item.ProfileImageURL = h.resolveURL(ctx, item.ProfileImageURL)
+	item.BaseAppearanceReferenceURL = h.resolveURL(ctx, item.BaseAppearanceReferenceURL)
+	if a.Images != nil {
+		item.Images = &ImageRenditions{
+			Thumb:  h.resolveURL(ctx, a.Images.Thumb),
+			Medium: h.resolveURL(ctx, a.Images.Medium),
+			Full:   h.resolveURL(ctx, a.Images.Full),
+		}
+	}
+	item.PortraitCandidates = h.resolveURLs(ctx, item.PortraitCandidates)
+	return item
+}
+
+func (h *AgentHandler) presentPhotos(ctx context.Context, photos []GalleryPhoto) []GalleryPhoto {
+	for i := range photos {
+		photos[i].URL = h.resolveURL(ctx, photos[i].URL)
+	}
+	return photos
+}
+
+func (h *AgentHandler) presentProfiles(ctx context.Context, profiles []AgentSocialProfile) []AgentSocialProfile {
+	for i := range profiles {
+		profiles[i].ProfileURL = h.resolveURL(ctx, profiles[i].ProfileURL)
+		profiles[i].Photos = h.presentPhotos(ctx, profiles[i].Photos)
+	}
+	return profiles
+}
+
+func (h *AgentHandler) presentImageHistory(ctx context.Context, history []ImageHistoryEntry) []ImageHistoryEntry {
+	presented := make([]ImageHistoryEntry, len(history))
+	for i, entry := range history {
+		entry.URL = h.resolveURL(ctx, entry.URL)
+		presented[i] = entry
+	}
+	return presented
+}
+
+func (h *AgentHandler) presentParts(ctx context.Context, parts []messagePart) []messagePart {
+	presented := make([]messagePart, len(parts))
+	for i, part := range parts {
+		part.URL = h.resolveURL(ctx, part.URL)
+		presented[i] = part
+	}
+	return presented
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"buddy-agent/service/dbservice"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migrations lists the schema changes of the collections the agent handler owns. Append
+// new migrations with the next version; never edit or renumber one that has shipped.
+//
+// Migration 1 runs while replicas of the previous release may still write the misspelled
+// field, so Agent.UnmarshalBSON keeps reading it (see legacyBaseAppearanceField). Drop
+// that fallback together with a migration repeating the rename once no such replica is
+// left.
+func Migrations() []dbservice.Migration {
+	return []dbservice.Migration{
+		renameField(1, "rename_base_appearance_reference_url", agentsCollection, legacyBaseAppearanceField, "base_appearance_reference_url"),
+	}
+}
+
+// Migrate applies the pending agent migrations, waiting for another replica that is
+// already migrating.
+func (h *AgentHandler) Migrate(ctx context.Context) (dbservice.MigrationReport, error) {
+	return h.db.Migrate(ctx, Migrations(), dbservice.MigrateOptions{Wait: true})
+}
+
+// renameField moves a field to a new name in every document that still has the old one.
+func renameField(version int, name, collection, from, to string) dbservice.Migration {
+	filter := bson.M{from: bson.M{"$exists": true}}
+	return dbservice.Migration{
+		Version: version,
+		Name:    name,
+		Up: func(ctx context.Context, db *mongo.Database) (int64, error) {
+			result, err := db.Collection(collection).UpdateMany(ctx, filter, bson.M{"$rename": bson.M{from: to}})
+			if err != nil {
+				return 0, err
+			}
+			return result.ModifiedCount, nil
+		},
+		Count: func(ctx context.Context, db *mongo.Database) (int64, error) {
+			return db.Collection(collection).CountDocuments(ctx, filter)
+		},
+	}
+}
+
+// legacyBaseAppearanceField is the misspelled name migration 1 renames.
+const legacyBaseAppearanceField = "base_appearance_referance_url"
+
+// UnmarshalBSON decodes an agent, taking the base appearance reference from the legacy
+// field when a document has it. recordBaseImage unsets the legacy field whenever it
+// writes the new one, so a legacy value was written later by a replica running the
+// previous release and is the current one.
+func (a *Agent) UnmarshalBSON(data []byte) error {
+	// Fields has Agent's fields without this method; it is exported so that the bson
+	// decoder inlines it.
+	type Fields Agent
+	var doc struct {
+		Fields `bson:",inline"`
+		Legacy string `bson:"base_appearance_referance_url,omitempty"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("decode agent: %w", err)
+	}
+	*a = Agent(doc.Fields)
+	if doc.Legacy != "" {
+		a.BaseAppearanceReferenceURL = doc.Legacy
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAgentDecodesLegacyBaseAppearanceField(t *testing.T) {
+	id := primitive.NewObjectID()
+	cases := []struct {
+		name string
+		doc  bson.M
+		want string
+	}{
+		{"migrated", bson.M{"_id": id, "name": "Rita", "base_appearance_reference_url": "new"}, "new"},
+		{"legacy only", bson.M{"_id": id, "name": "Rita", legacyBaseAppearanceField: "old"}, "old"},
+		{"written by the previous release after migrating", bson.M{"_id": id, "name": "Rita", "base_appearance_reference_url": "new", legacyBaseAppearanceField: "newer"}, "newer"},
+	}
+	for _, tc := range cases {
+		data, err := bson.Marshal(tc.doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a Agent
+		if err := bson.Unmarshal(data, &a); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if a.ID != id || a.Name != "Rita" || a.BaseAppearanceReferenceURL != tc.want {
+			t.Errorf("%s: decoded %+v, want reference %q", tc.name, a, tc.want)
+		}
+	}
+}
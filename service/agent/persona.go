@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	minPersonaAge         = 18
+	maxPersonaAge         = 120
+	maxNameLength         = 60
+	maxGenderLength       = 40
+	maxPersonalityLength  = 500
+	maxBackstoryLength    = 2000
+	maxSpeakingStyleLen   = 300
+	maxRelationshipLength = 80
+	maxInterests          = 12
+	maxInterestLength     = 60
+	maxCatchphrases       = 8
+	maxCatchphraseLength  = 120
+	maxBoundaries         = 12
+	maxBoundaryLength     = 200
+)
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Persona holds the character fields that shape how an agent talks and looks.
+type Persona struct {
+	Name          string   `json:"name" bson:"name"`
+	Personality   string   `json:"personality" bson:"personality"`
+	Gender        string   `json:"gender" bson:"gender"`
+	Age           int      `json:"age,omitempty" bson:"age,omitempty"`
+	Backstory     string   `json:"backstory,omitempty" bson:"backstory,omitempty"`
+	Interests     []string `json:"interests,omitempty" bson:"interests,omitempty"`
+	SpeakingStyle string   `json:"speaking_style,omitempty" bson:"speaking_style,omitempty"`
+	Catchphrases  []string `json:"catchphrases,omitempty" bson:"catchphrases,omitempty"`
+	Boundaries    []string `json:"boundaries,omitempty" bson:"boundaries,omitempty"`
+	Locale        string   `json:"locale,omitempty" bson:"locale,omitempty"`
+	Relationship  string   `json:"relationship,omitempty" bson:"relationship,omitempty"`
+}
+
+// normalize trims every field and drops empty list entries.
+func (p *Persona) normalize() {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Personality = strings.TrimSpace(p.Personality)
+	p.Gender = strings.TrimSpace(p.Gender)
+	p.Backstory = strings.TrimSpace(p.Backstory)
+	p.Interests = cleanList(p.Interests)
+	p.SpeakingStyle = strings.TrimSpace(p.SpeakingStyle)
+	p.Catchphrases = cleanList(p.Catchphrases)
+	p.Boundaries = cleanList(p.Boundaries)
+	p.Locale = strings.TrimSpace(p.Locale)
+	p.Relationship = strings.TrimSpace(p.Relationship)
+}
+
+// validate reports every problem with the persona so clients can fix them in one pass.
+func (p Persona) validate() error {
+	var errs []error
+	if p.Name == "" || p.Personality == "" || p.Gender == "" {
+		errs = append(errs, errors.New("name, personality, and gender are required"))
+	}
+	errs = append(errs,
+		checkLength("name", p.Name, maxNameLength),
+		checkLength("personality", p.Personality, maxPersonalityLength),
+		checkLength("gender", p.Gender, maxGenderLength),
+		checkLength("backstory", p.Backstory, maxBackstoryLength),
+		checkLength("speaking_style", p.SpeakingStyle, maxSpeakingStyleLen),
+		checkLength("relationship", p.Relationship, maxRelationshipLength),
+		checkList("interests", p.Interests, maxInterests, maxInterestLength),
+		checkList("catchphrases", p.Catchphrases, maxCatchphrases, maxCatchphraseLength),
+		checkList("boundaries", p.Boundaries, maxBoundaries, maxBoundaryLength),
+	)
+	if p.Age != 0 && (p.Age < minPersonaAge || p.Age > maxPersonaAge) {
+		errs = append(errs, fmt.Errorf("age must be between %d and %d", minPersonaAge, maxPersonaAge))
+	}
+	if p.Locale != "" && !localePattern.MatchString(p.Locale) {
+		errs = append(errs, errors.New("locale must be a language tag such as en or pt-PT"))
+	}
+	return errors.Join(errs...)
+}
+
+func checkLength(field, value string, limit int) error {
+	if len([]rune(value)) > limit {
+		return fmt.Errorf("%s must be at most %d characters", field, limit)
+	}
+	return nil
+}
+
+func checkList(field string, values []string, maxItems, maxLength int) error {
+	if len(values) > maxItems {
+		return fmt.Errorf("%s accepts at most %d entries", field, maxItems)
+	}
+	for _, v := range values {
+		if len([]rune(v)) > maxLength {
+			return fmt.Errorf("%s entries must be at most %d characters", field, maxLength)
+		}
+	}
+	return nil
+}
+
+func cleanList(values []string) []string {
+	cleaned := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			cleaned = append(cleaned, v)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil
+	}
+	return cleaned
+}
+
+// validationMessage flattens joined validation errors into a single response line.
+func validationMessage(err error) string {
+	return strings.ReplaceAll(err.Error(), "\n", "; ")
+}
@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPersonaValidate(t *testing.T) {
+	valid := Persona{Name: "Rita", Personality: "sarcastic barista", Gender: "female", Age: 29, Locale: "pt-PT"}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("expected valid persona, got %v", err)
+	}
+
+	invalid := Persona{Name: "Rita", Age: 12, Locale: "not a locale", Interests: make([]string, maxInterests+1)}
+	err := invalid.validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	msg := validationMessage(err)
+	for _, want := range []string{"required", "age must be", "locale must be", "interests accepts"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("validation message %q missing %q", msg, want)
+		}
+	}
+}
+
+func TestCompileSystemPromptIncludesOptionalFields(t *testing.T) {
+	p := Persona{
+		Name:         "Rita",
+		Personality:  "sarcastic barista",
+		Gender:       "female",
+		Backstory:    "Runs a tiny cafe in Alfama.",
+		Catchphrases: []string{"Bica first, talk later"},
+		Boundaries:   []string{"No medical advice"},
+	}
+	prompt := compileSystemPrompt(p)
+	for _, want := range []string{"You are Rita", "Backstory: Runs a tiny cafe", `"Bica first, talk later"`, "- No medical advice"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("system prompt missing %q:\n%s", want, prompt)
+		}
+	}
+	if strings.Contains(prompt, "Interests") {
+		t.Errorf("system prompt should omit unset interests:\n%s", prompt)
+	}
+}
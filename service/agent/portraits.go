@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"buddy-agent/service/imagegen"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type selectPortraitRequest struct {
+	Index int `json:"index"`
+}
+
+// SelectPortrait makes one of the agent's generated portrait candidates its base image.
+// Agents created with several candidates use the first one until the creator picks.
+func (h *AgentHandler) SelectPortrait(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	var req selectPortraitRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+
+	stored, err := h.loadOwnedAgent(r.Context(), agentID, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if len(stored.PortraitCandidates) == 0 {
+		respondJSONError(w, http.StatusConflict, "agent has no portrait candidates to choose from")
+		return
+	}
+	if req.Index < 0 || req.Index >= len(stored.PortraitCandidates) {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("index must be between 0 and %d", len(stored.PortraitCandidates)-1))
+		return
+	}
+	imageCtx, imageCancel := context.WithTimeout(r.Context(), imageRequestTimeout)
+	defer imageCancel()
+	data, _, err := h.storage.ReadImage(imageCtx, stored.PortraitCandidates[req.Index])
+	if err != nil {
+		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to load candidate: %v", err))
+		return
+	}
+
+	// Drop the provisional portrait's gallery entry; persistBaseImage adds the chosen one.
+	h.removeGalleryPhotos(r.Context(), agentID, stored.BaseAppearanceReferenceURL)
+	prompt := compileImagePrompt(stored.Persona, stored.AppearanceDescription)
+	images, err := h.persistBaseImage(r.Context(), agentID, data, imageSourceGenerated, prompt)
+	if err != nil {
+		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to store portrait: %v", err))
+		return
+	}
+
+	stored.ProfileImageURL = images.Medium
+	stored.BaseAppearanceReferenceURL = images.Full
+	stored.Images = &images
+	stored.PlaceholderImage = false
+	stored.ImageSource = imageSourceGenerated
+	stored.PortraitCandidates = nil
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.presentAgent(r.Context(), stored))
+}
+
+// uploadPortraitCandidates uploads every generated candidate and returns their URLs.
+// If one upload fails, the ones already made are released.
+func (h *AgentHandler) uploadPortraitCandidates(ctx context.Context, agentID primitive.ObjectID, generated []imagegen.Image) ([]string, error) {
+	urls := make([]string, 0, len(generated))
+	for i, img := range generated {
+		uri, _, err := h.uploadProcessedImage(ctx, agentID, img.Data)
+		if err != nil {
+			h.releaseUploads(ctx, agentID, urls)
+			return nil, fmt.Errorf("store portrait candidate %d: %w", i, err)
+		}
+		urls = append(urls, uri)
+	}
+	return urls, nil
+}
+
+// recordPortraitCandidates stores the candidate URLs on the agent document.
+func (h *AgentHandler) recordPortraitCandidates(ctx context.Context, agentID primitive.ObjectID, urls []string) error {
+	collection := h.db.Database().Collection(agentsCollection)
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	if _, err := collection.UpdateByID(dbCtx, agentID, bson.M{"$set": bson.M{"portrait_candidates": urls}}); err != nil {
+		return fmt.Errorf("record portrait candidates: %w", err)
+	}
+	return nil
+}
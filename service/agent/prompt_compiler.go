@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// compileSystemPrompt turns a persona into the system prompt used for every chat turn.
+// Optional fields only contribute a line when they are set.
+func compileSystemPrompt(p Persona) string {
+	var b promptBuilder
+	b.line("You are %s, %s.", p.Name, describeIdentity(p))
+	b.line("Personality: %s.", strings.TrimSuffix(p.Personality, "."))
+	if p.Backstory != "" {
+		b.line("Backstory: %s", p.Backstory)
+	}
+	if len(p.Interests) > 0 {
+		b.line("Interests you enjoy talking about: %s.", strings.Join(p.Interests, ", "))
+	}
+	if p.SpeakingStyle != "" {
+		b.line("Speaking style: %s", p.SpeakingStyle)
+	}
+	if len(p.Catchphrases) > 0 {
+		b.line("Catchphrases you use naturally and sparingly: %s.", quoteList(p.Catchphrases))
+	}
+	if p.Relationship != "" {
+		b.line("Your relationship to the user: %s.", strings.TrimSuffix(p.Relationship, "."))
+	}
+	if p.Locale != "" {
+		b.line("Write for the %s locale unless the user writes in another language.", p.Locale)
+	}
+	if len(p.Boundaries) > 0 {
+		b.line("Boundaries you always respect:")
+		for _, boundary := range p.Boundaries {
+			b.line("- %s", boundary)
+		}
+	}
+	b.line("Answer warmly and stay in character.")
+	return b.String()
+}
+
+// compileAppearancePrompt asks the writer LLM for a short visual description of the persona.
+func compileAppearancePrompt(p Persona) string {
+	var b promptBuilder
+	b.line("You are crafting a short appearance description for a photorealistic portrait of %s.", p.Name)
+	b.line("The companion should feel like a real human: %s.", describeIdentity(p))
+	b.line("Describe their physical features, style, and outfit informed by this personality: %s.", strings.TrimSuffix(p.Personality, "."))
+	if len(p.Interests) > 0 {
+		b.line("Let their interests subtly show in their style: %s.", strings.Join(p.Interests, ", "))
+	}
+	if p.Backstory != "" {
+		b.line("Background for context: %s", p.Backstory)
+	}
+	b.line("Focus on visual cues only in 1-2 sentences.")
+	return b.String()
+}
+
+// compileImagePrompt builds the base portrait prompt sent to the image model.
+func compileImagePrompt(p Persona, appearanceDescription string) string {
+	var b promptBuilder
+	b.line("Create a front-facing, softly lit portrait of %s, %s.", p.Name, describeIdentity(p))
+	b.line("Appearance details: %s", appearanceDescription)
+	b.line("Personality cues: %s.", strings.TrimSuffix(p.Personality, "."))
+	b.line("Keep the pose relaxed, shoulders square, and expression gentle with a subtle smile so the image can be reused for future generations.")
+	return b.String()
+}
+
+// describeIdentity renders age, gender, and locale as a short phrase such as
+// "29 years old and presenting as female, rooted in the pt-PT locale".
+func describeIdentity(p Persona) string {
+	identity := fmt.Sprintf("presenting as %s", p.Gender)
+	if p.Age > 0 {
+		identity = fmt.Sprintf("%d years old and %s", p.Age, identity)
+	}
+	if p.Locale != "" {
+		identity = fmt.Sprintf("%s, rooted in the %s locale", identity, p.Locale)
+	}
+	return identity
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", v))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+type promptBuilder struct {
+	strings.Builder
+}
+
+func (b *promptBuilder) line(format string, args ...any) {
+	if b.Len() > 0 {
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(b, format, args...)
+}
+
+// compileScenePrompt asks the image model for a new picture of the persona that keeps the
+// face from the reference portrait sent alongside it. An empty outfit keeps their usual style.
+func compileScenePrompt(p Persona, appearanceDescription, scene, outfit string) string {
+	var b promptBuilder
+	b.line("The reference image shows %s. Create a new photorealistic image of this exact same person, %s.", p.Name, describeIdentity(p))
+	b.line("Keep their face, skin tone, hair, and distinguishing features identical to the reference.")
+	if appearanceDescription != "" {
+		b.line("Appearance details: %s", appearanceDescription)
+	}
+	b.line("Scene: %s", scene)
+	if outfit != "" {
+		b.line("Outfit: %s", outfit)
+	} else {
+		b.line("Dress them in an outfit that fits the scene and their usual style.")
+	}
+	b.line("Use natural lighting and a candid composition; do not add text or watermarks.")
+	return b.String()
+}
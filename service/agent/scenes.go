@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"buddy-agent/service/imagegen"
+)
+
+const (
+	maxSceneLength  = 300
+	maxOutfitLength = 200
+)
+
+type sceneRequest struct {
+	Scene  string `json:"scene"`
+	Outfit string `json:"outfit"`
+	// Caption and Public describe the gallery photo created for the scene.
+	Caption string `json:"caption"`
+	Public  bool   `json:"public"`
+}
+
+// GenerateAgentScene creates a new image of an existing agent in the requested scene or
+// outfit, conditioned on the agent's base portrait so the face stays the same.
+func (h *AgentHandler) GenerateAgentScene(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	var req sceneRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	req.Scene = strings.TrimSpace(req.Scene)
+	req.Outfit = strings.TrimSpace(req.Outfit)
+	if req.Scene == "" {
+		respondJSONError(w, http.StatusBadRequest, "scene is required")
+		return
+	}
+	if err := checkLength("scene", req.Scene, maxSceneLength); err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkLength("outfit", req.Outfit, maxOutfitLength); err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Caption = strings.TrimSpace(req.Caption)
+	if err := checkLength("caption", req.Caption, maxCaptionLength); err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stored, err := h.loadOwnedAgent(r.Context(), agentID, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	prompt := compileScenePrompt(stored.Persona, stored.AppearanceDescription, req.Scene, req.Outfit)
+	imageBytes, _, err := h.generateConsistentImage(r.Context(), stored, prompt)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	imageURL, _, err := h.uploadProcessedImage(r.Context(), agentID, imageBytes)
+	if err != nil {
+		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to store image: %v", err))
+		return
+	}
+	h.addGalleryPhoto(r.Context(), GalleryPhoto{
+		AgentID: agentID,
+		Kind:    photoKindScene,
+		URL:     imageURL,
+		Caption: req.Caption,
+		Prompt:  prompt,
+		Public:  req.Public,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"agent_id":  agentID,
+		"image_url": h.resolveURL(r.Context(), imageURL),
+		"prompt":    prompt,
+	})
+}
+
+// generateConsistentImage renders prompt with the agent's base portrait as a reference
+// image so new pictures show the same person.
+func (h *AgentHandler) generateConsistentImage(ctx context.Context, a Agent, prompt string) ([]byte, string, error) {
+	if h == nil || h.imageGen == nil || h.storage == nil {
+		return nil, "", fmt.Errorf("image generation dependencies missing")
+	}
+	if a.BaseAppearanceReferenceURL == "" || a.PlaceholderImage {
+		return nil, "", newRequestError(http.StatusConflict, "agent has no base portrait yet")
+	}
+	imageCtx, imageCancel := context.WithTimeout(ctx, imageRequestTimeout)
+	defer imageCancel()
+	refBytes, refMIME, err := h.storage.ReadImage(imageCtx, a.BaseAppearanceReferenceURL)
+	if err != nil {
+		return nil, "", newRequestError(http.StatusBadGateway, "failed to load base portrait: %v", err)
+	}
+	imageBytes, mimeType, err := h.imageGen.GenerateImageWithReferences(imageCtx, prompt, []imagegen.ReferenceImage{
+		{Data: refBytes, MIMEType: refMIME},
+	})
+	if err != nil {
+		return nil, "", newRequestError(http.StatusBadGateway, "failed to generate image: %v", err)
+	}
+	return imageBytes, mimeType, nil
+}
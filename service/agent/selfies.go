@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const defaultSelfieScene = "a casual smartphone selfie in their everyday surroundings"
+
+// imageIntentKeywords pre-filter chat messages so the classifier LLM only runs when a
+// message could plausibly be asking for a picture.
+var imageIntentKeywords = []string{
+	"photo", "pic", "picture", "selfie", "image", "snap", "show me", "what you look like", "see you",
+}
+
+// imageIntent is the classifier's verdict on whether a message asks for a picture.
+type imageIntent struct {
+	SendImage bool   `json:"send_image"`
+	Scene     string `json:"scene"`
+}
+
+// messagePart is one piece of a chat reply, either text or an image.
+type messagePart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	URL      string `json:"url,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+}
+
+// detectImageIntent decides whether the user is asking the agent to send a photo of
+// themselves. Classifier failures are treated as "no image" so chat keeps working.
+func (h *AgentHandler) detectImageIntent(ctx context.Context, prompt string) imageIntent {
+	lower := strings.ToLower(prompt)
+	matched := false
+	for _, keyword := range imageIntentKeywords {
+		if strings.Contains(lower, keyword) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return imageIntent{}
+	}
+	llmCtx, cancel := context.WithTimeout(ctx, llmRequestTimeout)
+	defer cancel()
+	reply, err := h.sendWriterPrompt(llmCtx, buildImageIntentPrompt(prompt))
+	if err != nil {
+		return imageIntent{}
+	}
+	var intent imageIntent
+	if err := json.Unmarshal([]byte(extractJSONObject(reply)), &intent); err != nil {
+		return imageIntent{}
+	}
+	intent.Scene = truncateRunes(strings.TrimSpace(intent.Scene), maxSceneLength)
+	if intent.SendImage && intent.Scene == "" {
+		intent.Scene = defaultSelfieScene
+	}
+	return intent
+}
+
+func buildImageIntentPrompt(message string) string {
+	return strings.TrimSpace(fmt.Sprintf(
+		`
+            A user is chatting with a companion character. Decide whether this message asks the companion to send a photo or selfie of themselves: %q.
+            Reply with a single JSON object and nothing else: {"send_image": true or false, "scene": "short description of the requested photo, or empty"}.
+        `,
+		message,
+	))
+}
+
+// generateChatImage renders and uploads an image of the agent for a chat reply.
+func (h *AgentHandler) generateChatImage(ctx context.Context, a Agent, scene string) (messagePart, error) {
+	prompt := compileScenePrompt(a.Persona, a.AppearanceDescription, scene, "")
+	imageBytes, _, err := h.generateConsistentImage(ctx, a, prompt)
+	if err != nil {
+		return messagePart{}, err
+	}
+	imageURL, mimeType, err := h.uploadProcessedImage(ctx, a.ID, imageBytes)
+	if err != nil {
+		return messagePart{}, fmt.Errorf("store chat image: %w", err)
+	}
+	// Chat pictures are private until the creator publishes them from the gallery.
+	h.addGalleryPhoto(ctx, GalleryPhoto{AgentID: a.ID, Kind: photoKindSelfie, URL: imageURL, Prompt: prompt})
+	return messagePart{Type: "image", URL: imageURL, MIMEType: mimeType}, nil
+}
+
+// withImageNote tells the chat model a photo accompanies its reply so the text can refer
+// to it naturally.
+func withImageNote(userPrompt, scene string) string {
+	return fmt.Sprintf("%s\n\n(You are attaching a photo of yourself to this reply: %s. Mention it naturally.)", userPrompt, scene)
+}
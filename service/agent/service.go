@@ -6,30 +6,31 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"buddy-agent/service/dbservice"
 	"buddy-agent/service/imagegen"
+	"buddy-agent/service/jobs"
 	"buddy-agent/service/llmservice"
 	"buddy-agent/service/storage"
 	userssvc "buddy-agent/service/users"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
-	envMongoDatabase        = "MONGO_DB_NAME"
-	envBaseFaceBucket       = "BASE_FACE_BUCKET"
-	envBaseFacePrefix       = "BASE_FACE_PREFIX"
-	envAWSRegion            = "AWS_REGION"
-	envImageModel           = "GOOGLE_IMAGE_MODEL"
-	defaultMongoDBName      = "buddy-agent"
 	agentsCollection        = "agents"
 	socialProfileCollection = "agent_social_profiles"
+	jobsCollection          = "jobs"
+	versionsCollection      = "agent_versions"
+	chatMessagesCollection  = "chat_messages"
+	galleryCollection       = "agent_gallery"
+	blobsCollection         = "blobs"
 	dbRequestTimeout        = 5 * time.Second
 	llmRequestTimeout       = 20 * time.Second
 	imageRequestTimeout     = 60 * time.Second
 	socialProfileJobTimeout = 90 * time.Second
+	placeholderRetryDelay   = 5 * time.Minute
 	maxSocialUsernameLength = 20
 )
 
@@ -39,41 +40,54 @@ var (
 	errInvalidAuthToken   = errors.New("invalid firebase token")
 )
 
-// NewAgentHandler initializes the Agent handler and underlying dependencies.
+// Config carries the settings NewAgentHandler needs. The chat model also serves the
+// writer client used for descriptions and persona drafts.
+type Config struct {
+	Mongo   dbservice.Config
+	LLM     llmservice.Config
+	Image   imagegen.Config
+	Storage storage.Config
+}
 
-func NewAgentHandler(ctx context.Context, usersHandler *userssvc.UserHandler) (*AgentHandler, error) {
-	svc, err := dbservice.New(ctx)
+// NewAgentHandler initializes the Agent handler and underlying dependencies.
+func NewAgentHandler(ctx context.Context, cfg Config, usersHandler *userssvc.UserHandler) (*AgentHandler, error) {
+	svc, err := dbservice.NewWithConfig(ctx, cfg.Mongo)
 	if err != nil {
 		return nil, err
 	}
-	llmConfig := llmservice.Config{
-		APIKey: os.Getenv("GOOGLE_API_KEY"),
-		Model:  os.Getenv("GOOGLE_CHAT_MODEL"),
-	}
-	llmClient, err := llmservice.NewClient(llmConfig)
+	llmClient, err := llmservice.NewClient(cfg.LLM)
 	if err != nil {
 		return nil, fmt.Errorf("init llm client: %w", err)
 	}
-	writerLLM, err := llmservice.NewClient(llmConfig)
+	writerLLM, err := llmservice.NewClient(cfg.LLM)
 	if err != nil {
 		return nil, fmt.Errorf("init writer llm client: %w", err)
 	}
-	imageClient, err := imagegen.New(ctx, imagegen.Config{
-		APIKey: os.Getenv("GOOGLE_API_KEY"),
-		Model:  os.Getenv(envImageModel),
-	})
+	imageClient, err := imagegen.New(ctx, cfg.Image)
 	if err != nil {
 		return nil, fmt.Errorf("init image client: %w", err)
 	}
-	storageSvc, err := storage.New(ctx, storage.Config{
-		Bucket: os.Getenv(envBaseFaceBucket),
-		Prefix: os.Getenv(envBaseFacePrefix),
-		Region: os.Getenv(envAWSRegion),
-	})
+	storageSvc, err := storage.Open(ctx, cfg.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("init storage service: %w", err)
+	}
+	jobQueue := jobs.NewQueue(svc.Database().Collection(jobsCollection))
+	return &AgentHandler{db: svc, llm: llmClient, writerLLM: writerLLM, imageGen: imageClient, storage: storageSvc, users: usersHandler, jobs: jobQueue}, nil
+}
+
+// NewStorageHandler initializes an Agent handler with only Mongo and storage, enough for
+// maintenance such as CollectGarbage but not for serving requests.
+func NewStorageHandler(ctx context.Context, cfg Config) (*AgentHandler, error) {
+	svc, err := dbservice.NewWithConfig(ctx, cfg.Mongo)
 	if err != nil {
+		return nil, err
+	}
+	storageSvc, err := storage.Open(ctx, cfg.Storage)
+	if err != nil {
+		svc.Close(context.Background())
 		return nil, fmt.Errorf("init storage service: %w", err)
 	}
-	return &AgentHandler{db: svc, llm: llmClient, writerLLM: writerLLM, imageGen: imageClient, storage: storageSvc, users: usersHandler}, nil
+	return &AgentHandler{db: svc, storage: storageSvc}, nil
 }
 
 // Close releases the underlying database resources.
@@ -87,19 +101,47 @@ func (h *AgentHandler) Close(ctx context.Context) error {
 	)
 }
 
-func mongoDatabaseName() string {
-	if name := strings.TrimSpace(os.Getenv(envMongoDatabase)); name != "" {
-		return name
-	}
-	return defaultMongoDBName
-}
-
 func respondJSONError(w http.ResponseWriter, status int, msg string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
+// requestError carries the HTTP status a handler should report for a failed operation.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+func newRequestError(status int, format string, args ...any) error {
+	return &requestError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// respondError writes err using the status of a requestError, or 500 for anything else.
+func respondError(w http.ResponseWriter, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		respondJSONError(w, reqErr.status, reqErr.msg)
+		return
+	}
+	respondJSONError(w, http.StatusInternalServerError, err.Error())
+}
+
+// agentIDFromQuery parses the required agentId query parameter.
+func agentIDFromQuery(r *http.Request) (primitive.ObjectID, error) {
+	agentIDHex := strings.TrimSpace(r.URL.Query().Get("agentId"))
+	if agentIDHex == "" {
+		return primitive.NilObjectID, newRequestError(http.StatusBadRequest, "agentId is required")
+	}
+	agentID, err := primitive.ObjectIDFromHex(agentIDHex)
+	if err != nil {
+		return primitive.NilObjectID, newRequestError(http.StatusBadRequest, "invalid agentId")
+	}
+	return agentID, nil
+}
+
 func (h *AgentHandler) sendWriterPrompt(ctx context.Context, prompt string) (string, error) {
 	if h == nil || h.writerLLM == nil {
 		return "", fmt.Errorf("writer llm client not initialized")
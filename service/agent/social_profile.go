@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -14,7 +13,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // GetAgentSocialProfile loads the generated social profile for a given agent or profile id.
@@ -55,7 +53,7 @@ func (h *AgentHandler) GetAgentSocialProfile(w http.ResponseWriter, r *http.Requ
 
 	dbCtx, dbCancel := context.WithTimeout(r.Context(), dbRequestTimeout)
 	defer dbCancel()
-	collection := h.db.Client().Database(mongoDatabaseName()).Collection(socialProfileCollection)
+	collection := h.db.Database().Collection(socialProfileCollection)
 
 	var profile AgentSocialProfile
 	var lastErr error
@@ -81,6 +79,12 @@ func (h *AgentHandler) GetAgentSocialProfile(w http.ResponseWriter, r *http.Requ
 		respondJSONError(w, status, msg)
 		return
 	}
+	withPhotos := []AgentSocialProfile{profile}
+	if err := h.attachPublicPhotos(r.Context(), withPhotos); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to load photos: %v", err))
+		return
+	}
+	profile = h.presentProfiles(r.Context(), withPhotos)[0]
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(profile); err != nil {
@@ -101,7 +105,7 @@ func (h *AgentHandler) ListAgentSocialProfiles(w http.ResponseWriter, r *http.Re
 
 	dbCtx, dbCancel := context.WithTimeout(r.Context(), dbRequestTimeout)
 	defer dbCancel()
-	collection := h.db.Client().Database(mongoDatabaseName()).Collection(socialProfileCollection)
+	collection := h.db.Database().Collection(socialProfileCollection)
 	filter := bson.M{"created_by": requester.ID}
 	cursor, err := collection.Find(dbCtx, filter)
 	if err != nil {
@@ -115,56 +119,42 @@ func (h *AgentHandler) ListAgentSocialProfiles(w http.ResponseWriter, r *http.Re
 		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to load social profiles: %v", err))
 		return
 	}
+	if err := h.attachPublicPhotos(r.Context(), profiles); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to load photos: %v", err))
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]any{"profiles": profiles}); err != nil {
+	if err := json.NewEncoder(w).Encode(map[string]any{"profiles": h.presentProfiles(r.Context(), profiles)}); err != nil {
 		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
 	}
 }
 
-func (h *AgentHandler) createInitialSocialProfile(ctx context.Context, agentID primitive.ObjectID, username string, createdBy primitive.ObjectID) error {
-	if h == nil || h.db == nil {
-		return fmt.Errorf("handler not initialized")
-	}
-	username = strings.TrimSpace(username)
-	if username == "" {
-		username = fmt.Sprintf("agent_%s", agentID.Hex())
+// withUniqueUsername calls write with username and, while the unique username index
+// rejects it, with variants suffixed by the agent id. The last variant embeds the whole
+// id, so it cannot collide with another agent's.
+func withUniqueUsername(agentID primitive.ObjectID, username string, write func(string) error) error {
+	id := agentID.Hex()
+	candidates := []string{username}
+	if base := sanitizeUsername(username); base != "" {
+		runes := []rune(base)
+		if len(runes) > maxSocialUsernameLength-7 {
+			runes = runes[:maxSocialUsernameLength-7]
+		}
+		candidates = append(candidates, string(runes)+"_"+id[len(id)-6:])
 	}
-	profiles := h.db.Client().Database(mongoDatabaseName()).Collection(socialProfileCollection)
-	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
-	defer dbCancel()
-	now := time.Now().UTC()
-	update := bson.M{
-		"$setOnInsert": bson.M{
-			"agent_id":    agentID,
-			"username":    username,
-			"status":      "",
-			"profile_url": "",
-			"created_by":  createdBy,
-			"created_at":  now,
-		},
-		"$set": bson.M{
-			"updated_at": now,
-		},
-	}
-	opts := options.Update().SetUpsert(true)
-	if _, err := profiles.UpdateOne(dbCtx, bson.M{"agent_id": agentID}, update, opts); err != nil {
-		return fmt.Errorf("upsert initial social profile: %w", err)
+	candidates = append(candidates, "agent_"+id)
+	var err error
+	for _, candidate := range candidates {
+		if err = write(candidate); !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
 	}
-	return nil
+	return err
 }
 
 func (h *AgentHandler) launchSocialProfileJob(agentID primitive.ObjectID) {
-	if h == nil {
-		return
-	}
-	go func(id primitive.ObjectID) {
-		ctx, cancel := context.WithTimeout(context.Background(), socialProfileJobTimeout)
-		defer cancel()
-		if err := h.generateAndPersistSocialProfile(ctx, id); err != nil {
-			log.Printf("social profile generation failed for %s: %v", id.Hex(), err)
-		}
-	}(agentID)
+	h.enqueueAgentJob(jobSocialProfile, agentID, 0)
 }
 
 func (h *AgentHandler) generateAndPersistSocialProfile(ctx context.Context, agentID primitive.ObjectID) error {
@@ -174,7 +164,7 @@ func (h *AgentHandler) generateAndPersistSocialProfile(ctx context.Context, agen
 	if h.db == nil || h.llm == nil || h.imageGen == nil || h.storage == nil {
 		return fmt.Errorf("social profile dependencies missing")
 	}
-	agentCollection := h.db.Client().Database(mongoDatabaseName()).Collection(agentsCollection)
+	agentCollection := h.db.Database().Collection(agentsCollection)
 	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
 	defer dbCancel()
 	var stored Agent
@@ -190,18 +180,23 @@ func (h *AgentHandler) generateAndPersistSocialProfile(ctx context.Context, agen
 		return err
 	}
 	now := time.Now().UTC()
-	profiles := h.db.Client().Database(mongoDatabaseName()).Collection(socialProfileCollection)
+	profiles := h.db.Database().Collection(socialProfileCollection)
 	updateCtx, updateCancel := context.WithTimeout(ctx, dbRequestTimeout)
 	defer updateCancel()
-	update := bson.M{
-		"$set": bson.M{
-			"username":    username,
-			"status":      status,
-			"profile_url": stored.BaseAppearanceReferenceURL,
-			"updated_at":  now,
-		},
-	}
-	result, err := profiles.UpdateOne(updateCtx, bson.M{"agent_id": agentID}, update)
+	var result *mongo.UpdateResult
+	err = withUniqueUsername(agentID, username, func(candidate string) error {
+		update := bson.M{
+			"$set": bson.M{
+				"username":    candidate,
+				"status":      status,
+				"profile_url": stored.BaseAppearanceReferenceURL,
+				"updated_at":  now,
+			},
+		}
+		var err error
+		result, err = profiles.UpdateOne(updateCtx, bson.M{"agent_id": agentID}, update)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("update social profile: %w", err)
 	}
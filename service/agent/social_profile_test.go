@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestWithUniqueUsername(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("65f0c0ffee0000000000abcd")
+	taken := map[string]bool{"Luna Star": true, "lunastar_00abcd": true}
+	var tried []string
+	err := withUniqueUsername(id, "Luna Star", func(candidate string) error {
+		tried = append(tried, candidate)
+		if taken[candidate] {
+			return mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withUniqueUsername: %v", err)
+	}
+	want := []string{"Luna Star", "lunastar_00abcd", "agent_65f0c0ffee0000000000abcd"}
+	if len(tried) != len(want) {
+		t.Fatalf("tried %q, want %q", tried, want)
+	}
+	for i := range want {
+		if tried[i] != want[i] {
+			t.Fatalf("tried %q, want %q", tried, want)
+		}
+	}
+}
+
+func TestWriteOrUndoStopsUsernameRetriesAfterFailedCleanup(t *testing.T) {
+	id := primitive.NewObjectID()
+	duplicate := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}
+	for _, undoErr := range []error{nil, errors.New("network down")} {
+		var writes, undos int
+		err := withUniqueUsername(id, "Luna", func(string) error {
+			return writeOrUndo(id,
+				func() error { writes++; return duplicate },
+				func() error { undos++; return undoErr })
+		})
+		if undos != writes {
+			t.Fatalf("undo ran %d times for %d failed writes", undos, writes)
+		}
+		if undoErr == nil {
+			if writes != 3 || !mongo.IsDuplicateKeyError(err) {
+				t.Fatalf("clean undo: %d writes, err %v; want every candidate tried", writes, err)
+			}
+			continue
+		}
+		if writes != 1 || mongo.IsDuplicateKeyError(err) || !strings.Contains(err.Error(), "partially stored") {
+			t.Fatalf("failed undo: %d writes, err %v; want a single attempt reporting the leftovers", writes, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"buddy-agent/service/dbservice"
 	"buddy-agent/service/imagegen"
+	"buddy-agent/service/jobs"
 	"buddy-agent/service/llmservice"
 	"buddy-agent/service/storage"
 	userssvc "buddy-agent/service/users"
@@ -17,35 +18,73 @@ type AgentHandler struct {
 	llm       *llmservice.Client
 	writerLLM *llmservice.Client
 	imageGen  *imagegen.Service
-	storage   *storage.Service
+	storage   storage.Store
 	users     *userssvc.UserHandler
+	jobs      *jobs.Queue
 }
 
 // Agent represents the payload used to create a new agent profile.
 type Agent struct {
 	ID                         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Name                       string             `json:"name" bson:"name"`
-	Personality                string             `json:"personality" bson:"personality"`
-	Gender                     string             `json:"gender" bson:"gender"`
-	SystemPrompt               string             `json:"system_prompt,omitempty" bson:"system_prompt,omitempty"`
-	ProfileImageURL            string             `json:"profile_image_url,omitempty" bson:"profile_image_url,omitempty"`
-	AppearanceDescription      string             `json:"appearance_description,omitempty" bson:"appearance_description,omitempty"`
-	BaseAppearanceReferenceURL string             `json:"base_appearance_referance_url,omitempty" bson:"base_appearance_referance_url,omitempty"`
-	CreatedBy                  primitive.ObjectID `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Persona                    `bson:",inline"`
+	SystemPrompt               string              `json:"system_prompt,omitempty" bson:"system_prompt,omitempty"`
+	ProfileImageURL            string              `json:"profile_image_url,omitempty" bson:"profile_image_url,omitempty"`
+	AppearanceDescription      string              `json:"appearance_description,omitempty" bson:"appearance_description,omitempty"`
+	BaseAppearanceReferenceURL string              `json:"base_appearance_referance_url,omitempty" bson:"base_appearance_reference_url,omitempty"`
+	CreatedBy                  primitive.ObjectID  `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreatedAt                  time.Time           `json:"created_at" bson:"created_at"`
+	Version                    int                 `json:"version,omitempty" bson:"version,omitempty"`
+	ForkedFrom                 *primitive.ObjectID `json:"forked_from,omitempty" bson:"forked_from,omitempty"`
+	Images                     *ImageRenditions    `json:"images,omitempty" bson:"images,omitempty"`
+	// PlaceholderImage is set while the base image is a procedural avatar standing in for a
+	// portrait that could not be generated yet.
+	PlaceholderImage bool `json:"placeholder_image,omitempty" bson:"placeholder_image,omitempty"`
+	// ImageSource says where the current base image came from (generated, uploaded, ...).
+	ImageSource string `json:"image_source,omitempty" bson:"image_source,omitempty"`
+	// ImageHistory keeps the base images that were replaced, oldest first.
+	ImageHistory []ImageHistoryEntry `json:"image_history,omitempty" bson:"image_history,omitempty"`
+	// PortraitCandidates are alternative generated portraits awaiting the creator's pick.
+	PortraitCandidates []string `json:"portrait_candidates,omitempty" bson:"portrait_candidates,omitempty"`
+}
+
+// createAgentRequest is the CreateAgent body: the agent's persona plus portrait options.
+type createAgentRequest struct {
+	Agent
+	ImageOptions imagegen.Options `json:"image_options"`
+}
+
+// ImageHistoryEntry is a base image that has since been replaced.
+type ImageHistoryEntry struct {
+	URL        string    `json:"url" bson:"url"`
+	Source     string    `json:"source,omitempty" bson:"source,omitempty"`
+	ReplacedAt time.Time `json:"replaced_at" bson:"replaced_at"`
+}
+
+// ImageRenditions holds the URLs of the resized copies of an agent's base portrait.
+type ImageRenditions struct {
+	Thumb  string `json:"thumb" bson:"thumb"`
+	Medium string `json:"medium" bson:"medium"`
+	Full   string `json:"full" bson:"full"`
 }
 
 type agentListItem struct {
-	ID                         primitive.ObjectID `json:"id"`
-	Name                       string             `json:"name"`
-	Personality                string             `json:"personality"`
-	Gender                     string             `json:"gender"`
-	ProfileImageURL            string             `json:"profile_image_url,omitempty"`
-	AppearanceDescription      string             `json:"appearance_description,omitempty"`
-	BaseAppearanceReferenceURL string             `json:"base_appearance_referance_url,omitempty"`
+	ID primitive.ObjectID `json:"id"`
+	Persona
+	Version                    int                 `json:"version,omitempty"`
+	ForkedFrom                 *primitive.ObjectID `json:"forked_from,omitempty"`
+	ProfileImageURL            string              `json:"profile_image_url,omitempty"`
+	AppearanceDescription      string              `json:"appearance_description,omitempty"`
+	BaseAppearanceReferenceURL string              `json:"base_appearance_referance_url,omitempty"`
+	Images                     *ImageRenditions    `json:"images,omitempty"`
+	PlaceholderImage           bool                `json:"placeholder_image,omitempty"`
+	ImageSource                string              `json:"image_source,omitempty"`
+	PortraitCandidates         []string            `json:"portrait_candidates,omitempty"`
 }
 
 type chatRequest struct {
 	Prompt string `json:"prompt"`
+	// Attachments are URLs of images previously uploaded with a message to this agent.
+	Attachments []string `json:"attachments"`
 }
 
 // AgentSocialProfile represents the social presence for an agent that lives
@@ -59,4 +98,62 @@ type AgentSocialProfile struct {
 	CreatedBy  primitive.ObjectID `json:"created_by" bson:"created_by"`
 	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+	// Photos is the grid of public gallery photos, filled in when the profile is served.
+	Photos []GalleryPhoto `json:"photos,omitempty" bson:"-"`
+}
+
+// GalleryPhoto is one image in an agent's gallery: its base portraits, generated scenes,
+// chat selfies and creator uploads.
+type GalleryPhoto struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AgentID   primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	Kind      string             `json:"kind" bson:"kind"`
+	URL       string             `json:"url" bson:"url"`
+	Caption   string             `json:"caption,omitempty" bson:"caption,omitempty"`
+	Prompt    string             `json:"prompt,omitempty" bson:"prompt,omitempty"`
+	Public    bool               `json:"public" bson:"public"`
+	Position  int                `json:"position" bson:"position"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// AgentVersion is an immutable snapshot of an agent's persona and system prompt. A new
+// version is stored whenever the creator edits or rolls back the agent.
+type AgentVersion struct {
+	ID                    primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	AgentID               primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	Version               int                `json:"version" bson:"version"`
+	Persona               Persona            `json:"persona" bson:"persona"`
+	SystemPrompt          string             `json:"system_prompt" bson:"system_prompt"`
+	AppearanceDescription string             `json:"appearance_description,omitempty" bson:"appearance_description,omitempty"`
+	Note                  string             `json:"note,omitempty" bson:"note,omitempty"`
+	RestoredFrom          int                `json:"restored_from,omitempty" bson:"restored_from,omitempty"`
+	CreatedBy             primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt             time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// ChatMessage records a single chat exchange together with the agent version that
+// produced the reply.
+type ChatMessage struct {
+	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	AgentID      primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	AgentVersion int                `json:"agent_version" bson:"agent_version"`
+	Prompt       string             `json:"prompt" bson:"prompt"`
+	Attachments  []string           `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	Response     string             `json:"response" bson:"response"`
+	ImageURLs    []string           `json:"image_urls,omitempty" bson:"image_urls,omitempty"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Blob records a stored image object and the agents it was uploaded for. Objects are
+// content-addressed, so agents with identical images share one blob.
+type Blob struct {
+	Key         string               `json:"key" bson:"_id"`
+	URL         string               `json:"url" bson:"url"`
+	ContentType string               `json:"content_type" bson:"content_type"`
+	Size        int                  `json:"size" bson:"size"`
+	AgentIDs    []primitive.ObjectID `json:"agent_ids" bson:"agent_ids"`
+	CreatedAt   time.Time            `json:"created_at" bson:"created_at"`
+	// UploadedAt is bumped on every upload of the same bytes, so garbage collection can
+	// tell an old object apart from one that was just reused.
+	UploadedAt time.Time `json:"uploaded_at" bson:"uploaded_at"`
 }
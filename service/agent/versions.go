@@ -0,0 +1,504 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type agentUpdateRequest struct {
+	Persona
+	Note string `json:"note"`
+}
+
+type versionChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// UpdateAgent replaces an agent's persona, recompiles its system prompt, and stores the
+// result as a new immutable version.
+func (h *AgentHandler) UpdateAgent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	var req agentUpdateRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	req.Persona.normalize()
+	if err := req.Persona.validate(); err != nil {
+		respondJSONError(w, http.StatusBadRequest, validationMessage(err))
+		return
+	}
+
+	stored, err := h.loadOwnedAgent(r.Context(), agentID, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	updated, err := h.commitVersion(r.Context(), stored, req.Persona, compileSystemPrompt(req.Persona), stored.AppearanceDescription, strings.TrimSpace(req.Note), 0, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.presentAgent(r.Context(), updated)); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
+	}
+}
+
+// ListAgentVersions returns every stored version of an agent, newest first.
+func (h *AgentHandler) ListAgentVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	stored, err := h.loadOwnedAgent(r.Context(), agentID, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	dbCtx, dbCancel := context.WithTimeout(r.Context(), dbRequestTimeout)
+	defer dbCancel()
+	collection := h.db.Database().Collection(versionsCollection)
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	cursor, err := collection.Find(dbCtx, bson.M{"agent_id": agentID}, opts)
+	if err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to fetch versions: %v", err))
+		return
+	}
+	defer cursor.Close(dbCtx)
+	versions := make([]AgentVersion, 0)
+	if err := cursor.All(dbCtx, &versions); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to load versions: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"agent_id":        agentID,
+		"current_version": stored.Version,
+		"versions":        versions,
+	}); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
+	}
+}
+
+// DiffAgentVersions compares two versions field by field, with a line diff of the
+// system prompt. The "to" version defaults to the current one.
+func (h *AgentHandler) DiffAgentVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	stored, err := h.loadOwnedAgent(r.Context(), agentID, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	from, err := versionParam(query.Get("from"), 0)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	to, err := versionParam(query.Get("to"), currentVersion(stored))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if from == 0 {
+		respondJSONError(w, http.StatusBadRequest, "from is required")
+		return
+	}
+
+	fromVersion, err := h.agentVersion(r.Context(), stored, from)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	toVersion, err := h.agentVersion(r.Context(), stored, to)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"agent_id":           agentID,
+		"from":               from,
+		"to":                 to,
+		"changes":            diffVersions(fromVersion, toVersion),
+		"system_prompt_diff": diffLines(fromVersion.SystemPrompt, toVersion.SystemPrompt),
+	}); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
+	}
+}
+
+// RollbackAgentVersion restores an earlier version by committing a copy of it as the
+// newest version, so history stays append-only.
+func (h *AgentHandler) RollbackAgentVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requester, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+	agentID, err := agentIDFromQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	target, err := versionParam(r.URL.Query().Get("version"), 0)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if target == 0 {
+		respondJSONError(w, http.StatusBadRequest, "version is required")
+		return
+	}
+	stored, err := h.loadOwnedAgent(r.Context(), agentID, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if target == currentVersion(stored) {
+		respondJSONError(w, http.StatusConflict, fmt.Sprintf("version %d is already current", target))
+		return
+	}
+	previous, err := h.agentVersion(r.Context(), stored, target)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	note := fmt.Sprintf("rolled back to version %d", target)
+	// Reuse the stored prompt so a rollback restores exactly what that version said, even if
+	// the prompt compiler has changed since.
+	updated, err := h.commitVersion(r.Context(), stored, previous.Persona, previous.SystemPrompt, previous.AppearanceDescription, note, target, requester.ID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.presentAgent(r.Context(), updated)); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
+	}
+}
+
+// commitVersion stores persona, systemPrompt and appearance as the agent's next version
+// and moves the agent document to it. Only the versioned fields are written, so image changes made
+// since stored was loaded are kept. Agents created before versioning first get their
+// current state saved as version 1.
+func (h *AgentHandler) commitVersion(ctx context.Context, stored Agent, persona Persona, systemPrompt, appearance, note string, restoredFrom int, actor primitive.ObjectID) (Agent, error) {
+	currentFilter := bson.M{"_id": stored.ID, "version": stored.Version}
+	if stored.Version == 0 {
+		currentFilter = bson.M{"_id": stored.ID, "version": bson.M{"$exists": false}}
+		stored.Version = 1
+		if err := h.saveInitialVersion(ctx, newAgentVersion(stored, "", 0, stored.CreatedBy)); err != nil {
+			return Agent{}, err
+		}
+	}
+
+	next := stored
+	next.Persona = persona
+	next.SystemPrompt = systemPrompt
+	next.AppearanceDescription = appearance
+	next.Version = stored.Version + 1
+	version := newAgentVersion(next, note, restoredFrom, actor)
+	if err := h.insertVersion(ctx, version); err != nil {
+		return Agent{}, err
+	}
+
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	agents := h.db.Database().Collection(agentsCollection)
+	var updated Agent
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := agents.FindOneAndUpdate(dbCtx, currentFilter, versionUpdate(persona, systemPrompt, appearance, next.Version), opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = newRequestError(http.StatusConflict, "agent was modified concurrently; reload and try again")
+	}
+	if err != nil {
+		versions := h.db.Database().Collection(versionsCollection)
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.WithoutCancel(ctx), dbRequestTimeout)
+		_, cleanupErr := versions.DeleteOne(cleanupCtx, bson.M{"_id": version.ID})
+		cleanupCancel()
+		if cleanupErr != nil {
+			log.Printf("cleanup version %d of %s failed: %v", version.Version, stored.ID.Hex(), cleanupErr)
+		}
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			return Agent{}, err
+		}
+		return Agent{}, newRequestError(http.StatusInternalServerError, "failed to update agent: %v", err)
+	}
+	return updated, nil
+}
+
+// versionUpdate sets the fields a version owns on the agent document. Fields that are
+// empty in the new version are unset, matching how the document would be encoded.
+func versionUpdate(persona Persona, systemPrompt, appearance string, version int) bson.M {
+	set := bson.M{"version": version}
+	unset := bson.M{}
+	fields := reflect.TypeOf(persona)
+	values := reflect.ValueOf(persona)
+	for i := 0; i < fields.NumField(); i++ {
+		name, _, _ := strings.Cut(fields.Field(i).Tag.Get("bson"), ",")
+		if values.Field(i).IsZero() {
+			unset[name] = ""
+		} else {
+			set[name] = values.Field(i).Interface()
+		}
+	}
+	for name, value := range map[string]string{"system_prompt": systemPrompt, "appearance_description": appearance} {
+		if value == "" {
+			unset[name] = ""
+		} else {
+			set[name] = value
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// saveInitialVersion records a pre-versioning agent's state as version 1. It is an
+// upsert so that a version 1 left behind by an earlier failed edit does not block every
+// later one.
+func (h *AgentHandler) saveInitialVersion(ctx context.Context, version AgentVersion) error {
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	collection := h.db.Database().Collection(versionsCollection)
+	filter := bson.M{"agent_id": version.AgentID, "version": version.Version}
+	_, err := collection.UpdateOne(dbCtx, filter, bson.M{"$setOnInsert": version}, options.Update().SetUpsert(true))
+	// A concurrent edit may have upserted the same version first.
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return newRequestError(http.StatusInternalServerError, "failed to store agent version: %v", err)
+	}
+	return nil
+}
+
+func newAgentVersion(a Agent, note string, restoredFrom int, actor primitive.ObjectID) AgentVersion {
+	return AgentVersion{
+		ID:                    primitive.NewObjectID(),
+		AgentID:               a.ID,
+		Version:               a.Version,
+		Persona:               a.Persona,
+		SystemPrompt:          a.SystemPrompt,
+		AppearanceDescription: a.AppearanceDescription,
+		Note:                  note,
+		RestoredFrom:          restoredFrom,
+		CreatedBy:             actor,
+		CreatedAt:             time.Now().UTC(),
+	}
+}
+
+func (h *AgentHandler) insertVersion(ctx context.Context, version AgentVersion) error {
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	collection := h.db.Database().Collection(versionsCollection)
+	if _, err := collection.InsertOne(dbCtx, version); err != nil {
+		// The unique (agent_id, version) index turns a concurrent edit into a duplicate.
+		if mongo.IsDuplicateKeyError(err) {
+			return newRequestError(http.StatusConflict, "agent was modified concurrently; reload and try again")
+		}
+		return newRequestError(http.StatusInternalServerError, "failed to store agent version: %v", err)
+	}
+	return nil
+}
+
+// currentVersion returns the version a's current state has. Agents created before
+// versioning have none stored yet and are treated as version 1, the number commitVersion
+// saves their state under.
+func currentVersion(a Agent) int {
+	if a.Version == 0 {
+		return 1
+	}
+	return a.Version
+}
+
+// agentVersion loads version of a, taking version 1 of an agent created before
+// versioning from the agent itself.
+func (h *AgentHandler) agentVersion(ctx context.Context, a Agent, version int) (AgentVersion, error) {
+	if a.Version == 0 && version == 1 {
+		a.Version = 1
+		return newAgentVersion(a, "", 0, a.CreatedBy), nil
+	}
+	return h.loadVersion(ctx, a.ID, version)
+}
+
+func (h *AgentHandler) loadVersion(ctx context.Context, agentID primitive.ObjectID, version int) (AgentVersion, error) {
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	collection := h.db.Database().Collection(versionsCollection)
+	var stored AgentVersion
+	if err := collection.FindOne(dbCtx, bson.M{"agent_id": agentID, "version": version}).Decode(&stored); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return AgentVersion{}, newRequestError(http.StatusNotFound, "version %d not found", version)
+		}
+		return AgentVersion{}, newRequestError(http.StatusInternalServerError, "failed to load version: %v", err)
+	}
+	return stored, nil
+}
+
+// recordChatMessage stores a chat exchange with the agent version that produced it.
+// Failures are logged rather than surfaced because the reply was already generated.
+func (h *AgentHandler) recordChatMessage(ctx context.Context, agent Agent, prompt string, attachments []string, parts []messagePart) {
+	dbCtx, dbCancel := context.WithTimeout(ctx, dbRequestTimeout)
+	defer dbCancel()
+	collection := h.db.Database().Collection(chatMessagesCollection)
+	msg := ChatMessage{
+		AgentID:      agent.ID,
+		AgentVersion: agent.Version,
+		Prompt:       prompt,
+		Attachments:  attachments,
+		CreatedAt:    time.Now().UTC(),
+	}
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			msg.Response = part.Text
+		case "image":
+			msg.ImageURLs = append(msg.ImageURLs, part.URL)
+		}
+	}
+	if _, err := collection.InsertOne(dbCtx, msg); err != nil {
+		log.Printf("record chat message for %s failed: %v", agent.ID.Hex(), err)
+	}
+}
+
+func versionParam(raw string, fallback int) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return fallback, nil
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil || version < 1 {
+		return 0, newRequestError(http.StatusBadRequest, "invalid version %q", raw)
+	}
+	return version, nil
+}
+
+func diffVersions(from, to AgentVersion) []versionChange {
+	fields := []struct {
+		name     string
+		from, to any
+	}{
+		{"name", from.Persona.Name, to.Persona.Name},
+		{"personality", from.Persona.Personality, to.Persona.Personality},
+		{"gender", from.Persona.Gender, to.Persona.Gender},
+		{"age", from.Persona.Age, to.Persona.Age},
+		{"backstory", from.Persona.Backstory, to.Persona.Backstory},
+		{"interests", from.Persona.Interests, to.Persona.Interests},
+		{"speaking_style", from.Persona.SpeakingStyle, to.Persona.SpeakingStyle},
+		{"catchphrases", from.Persona.Catchphrases, to.Persona.Catchphrases},
+		{"boundaries", from.Persona.Boundaries, to.Persona.Boundaries},
+		{"locale", from.Persona.Locale, to.Persona.Locale},
+		{"relationship", from.Persona.Relationship, to.Persona.Relationship},
+		{"appearance_description", from.AppearanceDescription, to.AppearanceDescription},
+		{"system_prompt", from.SystemPrompt, to.SystemPrompt},
+	}
+	changes := make([]versionChange, 0)
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.from, f.to) {
+			changes = append(changes, versionChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+	return changes
+}
+
+// diffLines returns a minimal line diff of a and b where each line is prefixed with
+// "  " (unchanged), "- " (removed), or "+ " (added).
+func diffLines(a, b string) []string {
+	left := strings.Split(a, "\n")
+	right := strings.Split(b, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of left[i:] and right[j:].
+	lcs := make([][]int, len(left)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(right)+1)
+	}
+	for i := len(left) - 1; i >= 0; i-- {
+		for j := len(right) - 1; j >= 0; j-- {
+			if left[i] == right[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	out := make([]string, 0, len(left)+len(right))
+	i, j := 0, 0
+	for i < len(left) && j < len(right) {
+		switch {
+		case left[i] == right[j]:
+			out = append(out, "  "+left[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+left[i])
+			i++
+		default:
+			out = append(out, "+ "+right[j])
+			j++
+		}
+	}
+	for ; i < len(left); i++ {
+		out = append(out, "- "+left[i])
+	}
+	for ; j < len(right); j++ {
+		out = append(out, "+ "+right[j])
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDiffLines(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{"equal", "a\nb", "a\nb", []string{"  a", "  b"}},
+		{"changed line", "a\nb\nc", "a\nx\nc", []string{"  a", "- b", "+ x", "  c"}},
+		{"appended", "a", "a\nb", []string{"  a", "+ b"}},
+		{"removed", "a\nb", "b", []string{"- a", "  b"}},
+		{"from empty", "", "a", []string{"- ", "+ a"}},
+	}
+	for _, tc := range cases {
+		if got := diffLines(tc.a, tc.b); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: diffLines = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	from := AgentVersion{Persona: Persona{Name: "Rita", Age: 29, Interests: []string{"coffee"}}, SystemPrompt: "v1"}
+	to := from
+	if changes := diffVersions(from, to); len(changes) != 0 {
+		t.Fatalf("identical versions differ: %+v", changes)
+	}
+	to.Persona.Age = 30
+	to.Persona.Interests = []string{"coffee", "fado"}
+	to.SystemPrompt = "v2"
+	changes := diffVersions(from, to)
+	var fields []string
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+	}
+	if want := []string{"age", "interests", "system_prompt"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("changed fields = %q, want %q", fields, want)
+	}
+	if changes[0].From != 29 || changes[0].To != 30 {
+		t.Fatalf("age change = %+v", changes[0])
+	}
+}
+
+func TestVersionUpdateTouchesOnlyVersionedFields(t *testing.T) {
+	update := versionUpdate(Persona{Name: "Rita", Personality: "dry", Gender: "female", Age: 29}, "prompt", "freckles", 3)
+	set := update["$set"].(bson.M)
+	unset := update["$unset"].(bson.M)
+	if set["name"] != "Rita" || set["age"] != 29 || set["system_prompt"] != "prompt" || set["appearance_description"] != "freckles" || set["version"] != 3 {
+		t.Fatalf("$set = %v", set)
+	}
+	if _, ok := unset["backstory"]; !ok {
+		t.Fatalf("$unset = %v, want the empty backstory cleared", unset)
+	}
+	if _, ok := versionUpdate(Persona{Name: "Rita"}, "prompt", "", 4)["$unset"].(bson.M)["appearance_description"]; !ok {
+		t.Fatal("an empty appearance description is not cleared")
+	}
+	for _, field := range []string{"images", "profile_image_url", "placeholder_image", "portrait_candidates"} {
+		if _, ok := set[field]; ok {
+			t.Errorf("$set overwrites %s", field)
+		}
+		if _, ok := unset[field]; ok {
+			t.Errorf("$unset clears %s", field)
+		}
+	}
+}
+
+func TestAgentVersionOfUnversionedAgent(t *testing.T) {
+	legacy := Agent{Persona: Persona{Name: "Rita"}, SystemPrompt: "prompt", AppearanceDescription: "freckles"}
+	if got := currentVersion(legacy); got != 1 {
+		t.Fatalf("currentVersion = %d, want 1", got)
+	}
+	version, err := (&AgentHandler{}).agentVersion(context.Background(), legacy, 1)
+	if err != nil {
+		t.Fatalf("agentVersion: %v", err)
+	}
+	if version.Version != 1 || version.Persona.Name != "Rita" || version.SystemPrompt != "prompt" || version.AppearanceDescription != "freckles" {
+		t.Fatalf("version 1 = %+v, want the agent's current state", version)
+	}
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const maxWizardBriefLength = 280
+
+type wizardRequest struct {
+	Brief string `json:"brief"`
+}
+
+// personaDraft is the wizard's proposal. Clients may edit it before sending it back to
+// AcceptPersonaDraft.
+type personaDraft struct {
+	Persona
+	AppearanceDescription string `json:"appearance_description"`
+}
+
+// DraftPersona expands a short brief such as "a sarcastic barista from Lisbon" into a
+// full persona draft using the writer LLM. Nothing is persisted.
+func (h *AgentHandler) DraftPersona(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if _, ok := h.requireUser(w, r); !ok {
+		return
+	}
+
+	var req wizardRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	req.Brief = strings.TrimSpace(req.Brief)
+	if req.Brief == "" {
+		respondJSONError(w, http.StatusBadRequest, "brief is required")
+		return
+	}
+	if len([]rune(req.Brief)) > maxWizardBriefLength {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("brief must be at most %d characters", maxWizardBriefLength))
+		return
+	}
+
+	draft, err := h.generatePersonaDraft(r.Context(), req.Brief)
+	if err != nil {
+		respondJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to draft persona: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"draft": draft}); err != nil {
+		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
+	}
+}
+
+// AcceptPersonaDraft creates an agent from a (possibly edited) wizard draft through the
+// same pipeline as CreateAgent, reusing the drafted appearance description.
+func (h *AgentHandler) AcceptPersonaDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	creator, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var draft personaDraft
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&draft); err != nil {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	created, err := h.createAgent(r.Context(), creator, newAgentSpec{
+		Persona:               draft.Persona,
+		AppearanceDescription: draft.AppearanceDescription,
+	})
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	h.respondCreatedAgent(w, r, created)
+}
+
+func (h *AgentHandler) generatePersonaDraft(ctx context.Context, brief string) (personaDraft, error) {
+	llmCtx, cancel := context.WithTimeout(ctx, llmRequestTimeout)
+	defer cancel()
+	reply, err := h.sendWriterPrompt(llmCtx, buildPersonaDraftPrompt(brief))
+	if err != nil {
+		return personaDraft{}, fmt.Errorf("persona draft prompt error: %w", err)
+	}
+	var draft personaDraft
+	if err := json.Unmarshal([]byte(extractJSONObject(reply)), &draft); err != nil {
+		return personaDraft{}, fmt.Errorf("persona draft was not valid json: %w", err)
+	}
+	draft.Persona.normalize()
+	draft.AppearanceDescription = strings.TrimSpace(draft.AppearanceDescription)
+	clampPersona(&draft.Persona)
+	if err := draft.Persona.validate(); err != nil {
+		return personaDraft{}, fmt.Errorf("persona draft invalid: %s", validationMessage(err))
+	}
+	return draft, nil
+}
+
+func buildPersonaDraftPrompt(brief string) string {
+	return strings.TrimSpace(fmt.Sprintf(
+		`
+            Expand this one-line character idea into a full companion persona: %q.
+            The character is an adult (18 or older) and should feel like a real, specific person.
+            Reply with a single JSON object and nothing else, using these keys:
+            "name" (string), "personality" (one sentence), "gender" (string), "age" (integer),
+            "backstory" (2-3 sentences), "interests" (array of up to 5 short strings),
+            "speaking_style" (one sentence), "catchphrases" (array of up to 3 short strings),
+            "locale" (language tag such as en-US or pt-PT), "relationship" (their relationship to the user, a few words),
+            "appearance_description" (1-2 sentences of visual cues for a photorealistic portrait).
+        `,
+		brief,
+	))
+}
+
+// extractJSONObject strips markdown fences or chatter around the first JSON object in text.
+func extractJSONObject(text string) string {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return strings.TrimSpace(text)
+	}
+	return text[start : end+1]
+}
+
+// clampPersona drops or trims imported and LLM-drafted values that would otherwise fail
+// validation on details the user can fix in the editor anyway.
+func clampPersona(p *Persona) {
+	if p.Age != 0 && (p.Age < minPersonaAge || p.Age > maxPersonaAge) {
+		p.Age = 0
+	}
+	if p.Locale != "" && !localePattern.MatchString(p.Locale) {
+		p.Locale = ""
+	}
+	p.Interests = truncateList(p.Interests, maxInterests)
+	p.Catchphrases = truncateList(p.Catchphrases, maxCatchphrases)
+	p.Boundaries = truncateList(p.Boundaries, maxBoundaries)
+}
+
+func truncateList(values []string, limit int) []string {
+	if len(values) > limit {
+		return values[:limit]
+	}
+	return values
+}
@@ -0,0 +1,176 @@
+// Package avatar renders offline placeholder portraits: a PNG derived deterministically from
+// a seed, showing the character's initials over colored shapes.
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"unicode"
+)
+
+// DefaultSize is the edge length, in pixels, used when Render is given a size <= 0.
+const DefaultSize = 512
+
+// Render draws a square avatar for seed (typically the agent ID) labelled with the
+// initials of name. The same seed and name always produce the same image.
+func Render(seed, name string, size int) ([]byte, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	sum := sha256.Sum256([]byte(seed))
+	hue := float64(sum[0]) / 255 * 360
+	background := hsl(hue, 0.45, 0.55)
+	accent := hsl(hue+150, 0.5, 0.7)
+	shade := hsl(hue+30, 0.4, 0.4)
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	fillRect(img, img.Bounds(), background)
+	// Three translucent circles placed by the hash give each avatar its own pattern.
+	for i := 0; i < 3; i++ {
+		b := sum[1+i*3 : 4+i*3]
+		cx := int(b[0]) * size / 255
+		cy := int(b[1]) * size / 255
+		r := size/6 + int(b[2])*size/(255*3)
+		c := accent
+		if i%2 == 1 {
+			c = shade
+		}
+		fillCircle(img, cx, cy, r, c, 0.45)
+	}
+
+	drawText(img, Initials(name), color.RGBA{R: 255, G: 255, B: 255, A: 255})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encode avatar: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Initials returns up to two upper-case initials from name, falling back to "?" when the
+// name has no letters or digits the built-in font can draw.
+func Initials(name string) string {
+	var out []rune
+	for _, word := range strings.Fields(name) {
+		for _, r := range word {
+			r = unicode.ToUpper(r)
+			if _, ok := glyphs[r]; ok && r != '?' {
+				out = append(out, r)
+				break
+			}
+		}
+		if len(out) == 2 {
+			break
+		}
+	}
+	if len(out) == 0 {
+		return "?"
+	}
+	return string(out)
+}
+
+func drawText(img *image.RGBA, text string, c color.RGBA) {
+	size := img.Bounds().Dx()
+	runes := []rune(text)
+	// Each glyph is glyphWidth cells wide with one blank cell between glyphs.
+	cells := len(runes)*(glyphWidth+1) - 1
+	scale := size / 2 / cells
+	if maxScale := size / 3 / glyphHeight; scale > maxScale {
+		scale = maxScale
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	x0 := (size - cells*scale) / 2
+	y0 := (size - glyphHeight*scale) / 2
+	for i, r := range runes {
+		glyph := glyphs[r]
+		gx := x0 + i*(glyphWidth+1)*scale
+		for row, bits := range glyph {
+			for col := 0; col < glyphWidth; col++ {
+				if bits[col] != '#' {
+					continue
+				}
+				fillRect(img, image.Rect(gx+col*scale, y0+row*scale, gx+(col+1)*scale, y0+(row+1)*scale), c)
+			}
+		}
+	}
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+func fillCircle(img *image.RGBA, cx, cy, radius int, c color.RGBA, alpha float64) {
+	r := image.Rect(cx-radius, cy-radius, cx+radius+1, cy+radius+1).Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dx, dy := x-cx, y-cy
+			if dx*dx+dy*dy > radius*radius {
+				continue
+			}
+			bg := img.RGBAAt(x, y)
+			img.SetRGBA(x, y, color.RGBA{
+				R: blend(bg.R, c.R, alpha),
+				G: blend(bg.G, c.G, alpha),
+				B: blend(bg.B, c.B, alpha),
+				A: 255,
+			})
+		}
+	}
+}
+
+func blend(a, b uint8, alpha float64) uint8 {
+	return uint8(float64(a)*(1-alpha) + float64(b)*alpha)
+}
+
+// hsl converts a hue in degrees plus saturation and lightness in [0,1] to an opaque color.
+func hsl(h, s, l float64) color.RGBA {
+	for h >= 360 {
+		h -= 360
+	}
+	c := (1 - abs(2*l-1)) * s
+	hp := h / 60
+	x := c * (1 - abs(mod2(hp)-1))
+	var r, g, b float64
+	switch {
+	case hp < 1:
+		r, g = c, x
+	case hp < 2:
+		r, g = x, c
+	case hp < 3:
+		g, b = c, x
+	case hp < 4:
+		g, b = x, c
+	case hp < 5:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	m := l - c/2
+	return color.RGBA{R: uint8((r + m) * 255), G: uint8((g + m) * 255), B: uint8((b + m) * 255), A: 255}
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func mod2(v float64) float64 {
+	for v >= 2 {
+		v -= 2
+	}
+	return v
+}
@@ -0,0 +1,43 @@
+package avatar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRenderIsDeterministic(t *testing.T) {
+	first, err := Render("65f1c0ffee", "Maria Lopez", 128)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	second, err := Render("65f1c0ffee", "Maria Lopez", 128)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatal("same seed rendered different avatars")
+	}
+	other, err := Render("65f1decade", "Maria Lopez", 128)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if bytes.Equal(first, other) {
+		t.Fatal("different seeds rendered identical avatars")
+	}
+}
+
+func TestInitials(t *testing.T) {
+	cases := map[string]string{
+		"Maria Lopez":     "ML",
+		"cher":            "C",
+		"Jean Luc Picard": "JL",
+		"  ":              "?",
+		"R2 D2":           "RD",
+		"¿¡ Zoë":          "Z",
+	}
+	for name, want := range cases {
+		if got := Initials(name); got != want {
+			t.Errorf("Initials(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package charcard
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Spec identifiers for Character Card V2.
+const (
+	SpecV2        = "chara_card_v2"
+	SpecVersionV2 = "2.0"
+)
+
+// Card is a Character Card V2 document as used by common roleplay frontends.
+type Card struct {
+	Spec        string `json:"spec"`
+	SpecVersion string `json:"spec_version"`
+	Data        Data   `json:"data"`
+}
+
+// Data holds the character fields of a V2 card. Extensions carries tool-specific data
+// that must be preserved when a card passes through other tools.
+type Data struct {
+	Name                    string                     `json:"name"`
+	Description             string                     `json:"description"`
+	Personality             string                     `json:"personality"`
+	Scenario                string                     `json:"scenario"`
+	FirstMes                string                     `json:"first_mes"`
+	MesExample              string                     `json:"mes_example"`
+	CreatorNotes            string                     `json:"creator_notes"`
+	SystemPrompt            string                     `json:"system_prompt"`
+	PostHistoryInstructions string                     `json:"post_history_instructions"`
+	AlternateGreetings      []string                   `json:"alternate_greetings"`
+	CharacterBook           json.RawMessage            `json:"character_book,omitempty"`
+	Tags                    []string                   `json:"tags"`
+	Creator                 string                     `json:"creator"`
+	CharacterVersion        string                     `json:"character_version"`
+	Extensions              map[string]json.RawMessage `json:"extensions"`
+}
+
+// v1Card is the flat legacy layout that predates the spec wrapper.
+type v1Card struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Personality string `json:"personality"`
+	Scenario    string `json:"scenario"`
+	FirstMes    string `json:"first_mes"`
+	MesExample  string `json:"mes_example"`
+}
+
+// New wraps data in a V2 card envelope, normalizing nil collections so the JSON matches
+// what other tools expect.
+func New(data Data) Card {
+	if data.AlternateGreetings == nil {
+		data.AlternateGreetings = []string{}
+	}
+	if data.Tags == nil {
+		data.Tags = []string{}
+	}
+	if data.Extensions == nil {
+		data.Extensions = map[string]json.RawMessage{}
+	}
+	return Card{Spec: SpecV2, SpecVersion: SpecVersionV2, Data: data}
+}
+
+// Parse decodes a V2 card, upgrading legacy V1 cards on the fly.
+func Parse(raw []byte) (Card, error) {
+	var probe struct {
+		Spec string `json:"spec"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return Card{}, fmt.Errorf("decode character card: %w", err)
+	}
+	switch strings.TrimSpace(probe.Spec) {
+	case SpecV2:
+		var card Card
+		if err := json.Unmarshal(raw, &card); err != nil {
+			return Card{}, fmt.Errorf("decode character card: %w", err)
+		}
+		card = New(card.Data)
+		return card, card.validate()
+	case "":
+		var legacy v1Card
+		if err := json.Unmarshal(raw, &legacy); err != nil {
+			return Card{}, fmt.Errorf("decode character card: %w", err)
+		}
+		card := New(Data{
+			Name:        legacy.Name,
+			Description: legacy.Description,
+			Personality: legacy.Personality,
+			Scenario:    legacy.Scenario,
+			FirstMes:    legacy.FirstMes,
+			MesExample:  legacy.MesExample,
+		})
+		return card, card.validate()
+	default:
+		return Card{}, fmt.Errorf("unsupported character card spec %q", probe.Spec)
+	}
+}
+
+// Extension decodes the named extension into v and reports whether it was present.
+func (c Card) Extension(name string, v any) (bool, error) {
+	raw, ok := c.Data.Extensions[name]
+	if !ok || len(raw) == 0 {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("decode %s extension: %w", name, err)
+	}
+	return true, nil
+}
+
+// SetExtension stores v as the named extension.
+func (c *Card) SetExtension(name string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s extension: %w", name, err)
+	}
+	if c.Data.Extensions == nil {
+		c.Data.Extensions = map[string]json.RawMessage{}
+	}
+	c.Data.Extensions[name] = raw
+	return nil
+}
+
+func (c Card) validate() error {
+	if strings.TrimSpace(c.Data.Name) == "" {
+		return fmt.Errorf("character card is missing a name")
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package charcard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// pngKeyword is the tEXt chunk keyword V2 cards are stored under.
+const pngKeyword = "chara"
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// ErrNoCard reports a PNG that does not carry character card metadata.
+var ErrNoCard = errors.New("png has no character card metadata")
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// IsPNG reports whether data starts with the PNG signature.
+func IsPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// FromPNG extracts the card stored in a PNG's "chara" tEXt chunk.
+func FromPNG(data []byte) (Card, error) {
+	chunks, err := readChunks(data)
+	if err != nil {
+		return Card{}, err
+	}
+	for _, chunk := range chunks {
+		if chunk.typ != "tEXt" {
+			continue
+		}
+		keyword, value, found := bytes.Cut(chunk.data, []byte{0})
+		if !found || string(keyword) != pngKeyword {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(string(value))
+		if err != nil {
+			return Card{}, fmt.Errorf("decode card chunk: %w", err)
+		}
+		return Parse(raw)
+	}
+	return Card{}, ErrNoCard
+}
+
+// EmbedPNG returns a copy of the PNG with the card stored in a "chara" tEXt chunk,
+// replacing any card metadata already present.
+func EmbedPNG(data []byte, card Card) ([]byte, error) {
+	chunks, err := readChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(card)
+	if err != nil {
+		return nil, fmt.Errorf("encode character card: %w", err)
+	}
+	text := append([]byte(pngKeyword+"\x00"), base64.StdEncoding.EncodeToString(raw)...)
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	for _, chunk := range chunks {
+		if chunk.typ == "tEXt" && bytes.HasPrefix(chunk.data, []byte(pngKeyword+"\x00")) {
+			continue
+		}
+		if chunk.typ == "IEND" {
+			writeChunk(&out, "tEXt", text)
+		}
+		writeChunk(&out, chunk.typ, chunk.data)
+	}
+	return out.Bytes(), nil
+}
+
+func readChunks(data []byte) ([]pngChunk, error) {
+	if !IsPNG(data) {
+		return nil, fmt.Errorf("not a png image")
+	}
+	var chunks []pngChunk
+	rest := data[len(pngSignature):]
+	for len(rest) > 0 {
+		if len(rest) < 12 {
+			return nil, fmt.Errorf("truncated png chunk")
+		}
+		length := binary.BigEndian.Uint32(rest[:4])
+		if uint64(length) > uint64(len(rest)-12) {
+			return nil, fmt.Errorf("truncated png chunk")
+		}
+		typ := string(rest[4:8])
+		body := rest[8 : 8+length]
+		sum := binary.BigEndian.Uint32(rest[8+length : 12+length])
+		if crc32.ChecksumIEEE(rest[4:8+length]) != sum {
+			return nil, fmt.Errorf("png chunk %s has a bad checksum", typ)
+		}
+		chunks = append(chunks, pngChunk{typ: typ, data: body})
+		rest = rest[12+length:]
+		if typ == "IEND" {
+			break
+		}
+	}
+	if len(chunks) == 0 || chunks[len(chunks)-1].typ != "IEND" {
+		return nil, fmt.Errorf("png is missing IEND chunk")
+	}
+	return chunks, nil
+}
+
+func writeChunk(buf *bytes.Buffer, typ string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+	buf.Write(header[:])
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	buf.Write(sum[:])
+}
@@ -0,0 +1,61 @@
+package charcard
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestEmbedPNGRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	if _, err := FromPNG(buf.Bytes()); !errors.Is(err, ErrNoCard) {
+		t.Fatalf("expected ErrNoCard for plain png, got %v", err)
+	}
+
+	card := New(Data{Name: "Rita", Personality: "sarcastic barista", Tags: []string{"coffee"}})
+	if err := card.SetExtension("buddy_agent", map[string]string{"gender": "female"}); err != nil {
+		t.Fatalf("set extension: %v", err)
+	}
+	embedded, err := EmbedPNG(buf.Bytes(), card)
+	if err != nil {
+		t.Fatalf("embed card: %v", err)
+	}
+	// Re-embedding must replace the existing chunk rather than append a second one.
+	embedded, err = EmbedPNG(embedded, card)
+	if err != nil {
+		t.Fatalf("re-embed card: %v", err)
+	}
+	if n := bytes.Count(embedded, []byte(pngKeyword+"\x00")); n != 1 {
+		t.Fatalf("expected one card chunk, found %d", n)
+	}
+	if _, err := png.Decode(bytes.NewReader(embedded)); err != nil {
+		t.Fatalf("embedded png no longer decodes: %v", err)
+	}
+
+	got, err := FromPNG(embedded)
+	if err != nil {
+		t.Fatalf("extract card: %v", err)
+	}
+	if got.Spec != SpecV2 || got.Data.Name != "Rita" || len(got.Data.Tags) != 1 {
+		t.Fatalf("unexpected card: %+v", got)
+	}
+	var ext map[string]string
+	if ok, err := got.Extension("buddy_agent", &ext); !ok || err != nil || ext["gender"] != "female" {
+		t.Fatalf("extension not preserved: ok=%v err=%v ext=%v", ok, err, ext)
+	}
+}
+
+func TestParseUpgradesV1Cards(t *testing.T) {
+	card, err := Parse([]byte(`{"name":"Old","description":"legacy card","first_mes":"hi"}`))
+	if err != nil {
+		t.Fatalf("parse v1 card: %v", err)
+	}
+	if card.Spec != SpecV2 || card.Data.Name != "Old" || card.Data.FirstMes != "hi" {
+		t.Fatalf("unexpected upgrade result: %+v", card)
+	}
+}
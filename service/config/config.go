@@ -0,0 +1,393 @@
+// Package config assembles the service configuration from defaults, an optional YAML
+// file, environment variables and command-line flags, in increasing order of precedence,
+// validates it once at startup and hands each service its typed settings.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"buddy-agent/cmd/chatcli"
+	"buddy-agent/service/agent"
+	"buddy-agent/service/dbservice"
+	"buddy-agent/service/httpserver"
+	"buddy-agent/service/imagegen"
+	"buddy-agent/service/jobs"
+	"buddy-agent/service/llmservice"
+	"buddy-agent/service/storage"
+	"buddy-agent/service/users"
+	"buddy-agent/service/worker"
+)
+
+// envConfigFile names the config file when --config is not given.
+const envConfigFile = "CONFIG_FILE"
+
+const redacted = "<redacted>"
+
+// Config is the complete service configuration.
+type Config struct {
+	Server   ServerConfig
+	Worker   WorkerConfig
+	Mongo    dbservice.Config
+	Google   GoogleConfig
+	Firebase FirebaseConfig
+	Storage  storage.Config
+	Chat     ChatConfig
+}
+
+// ServerConfig controls the HTTP listener.
+type ServerConfig struct {
+	Addr            string
+	ShutdownTimeout time.Duration
+	ProcessJobs     bool
+}
+
+// WorkerConfig controls background job processing.
+type WorkerConfig struct {
+	Concurrency int
+}
+
+// GoogleConfig holds the Generative Language API settings.
+type GoogleConfig struct {
+	APIKey     string
+	ChatModel  string
+	ImageModel string
+}
+
+// FirebaseConfig holds the Firebase settings used by the chat CLI.
+type FirebaseConfig struct {
+	DatabaseURL string
+}
+
+// ChatConfig controls the interactive chat CLI.
+type ChatConfig struct {
+	Role    string
+	Timeout time.Duration
+}
+
+// Default returns the configuration used before any file, environment or flag is applied.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{Addr: ":3000", ShutdownTimeout: 30 * time.Second, ProcessJobs: true},
+		Worker: WorkerConfig{Concurrency: 4},
+		Mongo:  dbservice.Config{Database: dbservice.DefaultDatabase},
+		Chat:   ChatConfig{Role: "user", Timeout: 2 * time.Minute},
+	}
+}
+
+// setting binds one configuration value to its file key, environment variables and flag.
+// The first environment variable that is set wins.
+type setting struct {
+	key    string
+	env    []string
+	flag   string
+	usage  string
+	secret bool
+	value  flag.Value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "server.addr", env: []string{"SERVICE_ADDR", "PORT"}, flag: "service-addr", usage: "HTTP service listen address; a bare port means all interfaces", value: addrValue{&c.Server.Addr}},
+		{key: "server.shutdown_timeout", env: []string{"SHUTDOWN_TIMEOUT"}, flag: "shutdown-timeout", usage: "How long shutdown waits for in-flight requests and background jobs before re-queueing them", value: durationValue{&c.Server.ShutdownTimeout}},
+		{key: "server.process_jobs", env: []string{"SERVICE_JOBS"}, flag: "service-jobs", usage: "Process background jobs inside the HTTP service (disable when running dedicated --worker processes)", value: boolValue{&c.Server.ProcessJobs}},
+		{key: "worker.concurrency", env: []string{"WORKER_CONCURRENCY"}, flag: "worker-concurrency", usage: "Maximum background jobs processed concurrently", value: intValue{&c.Worker.Concurrency}},
+		{key: "mongo.uri", env: []string{"MONGO_URI"}, secret: true, value: stringValue{&c.Mongo.URI}},
+		{key: "mongo.username", env: []string{"MONGO_DB_USERNAME"}, value: stringValue{&c.Mongo.Username}},
+		{key: "mongo.password", env: []string{"MONGO_DB_PASSWORD"}, secret: true, value: stringValue{&c.Mongo.Password}},
+		{key: "mongo.database", env: []string{"MONGO_DB_NAME"}, value: stringValue{&c.Mongo.Database}},
+		{key: "mongo.max_pool_size", env: []string{"MONGO_MAX_POOL_SIZE"}, value: intValue{&c.Mongo.MaxPoolSize}},
+		{key: "mongo.min_pool_size", env: []string{"MONGO_MIN_POOL_SIZE"}, value: intValue{&c.Mongo.MinPoolSize}},
+		{key: "mongo.connect_timeout", env: []string{"MONGO_CONNECT_TIMEOUT"}, value: durationValue{&c.Mongo.ConnectTimeout}},
+		{key: "mongo.server_selection_timeout", env: []string{"MONGO_SERVER_SELECTION_TIMEOUT"}, value: durationValue{&c.Mongo.ServerSelectionTimeout}},
+		{key: "mongo.operation_timeout", env: []string{"MONGO_OPERATION_TIMEOUT"}, value: durationValue{&c.Mongo.OperationTimeout}},
+		{key: "mongo.read_concern", env: []string{"MONGO_READ_CONCERN"}, value: stringValue{&c.Mongo.ReadConcern}},
+		{key: "mongo.read_preference", env: []string{"MONGO_READ_PREFERENCE"}, value: stringValue{&c.Mongo.ReadPreference}},
+		{key: "mongo.write_concern", env: []string{"MONGO_WRITE_CONCERN"}, value: stringValue{&c.Mongo.WriteConcern}},
+		{key: "mongo.tls", env: []string{"MONGO_TLS"}, value: boolValue{&c.Mongo.TLS}},
+		{key: "mongo.tls_ca_file", env: []string{"MONGO_TLS_CA_FILE"}, value: stringValue{&c.Mongo.TLSCAFile}},
+		{key: "mongo.tls_certificate_key_file", env: []string{"MONGO_TLS_CERTIFICATE_KEY_FILE"}, value: stringValue{&c.Mongo.TLSCertificateKeyFile}},
+		{key: "mongo.tls_insecure", env: []string{"MONGO_TLS_INSECURE"}, value: boolValue{&c.Mongo.TLSInsecure}},
+		{key: "google.api_key", env: []string{"GOOGLE_API_KEY"}, flag: "api-key", usage: "Google API key for the Generative Language API", secret: true, value: stringValue{&c.Google.APIKey}},
+		{key: "google.chat_model", env: []string{"GOOGLE_CHAT_MODEL"}, flag: "model", usage: "Google Generative Language model (default gemini-1.5-flash-latest)", value: stringValue{&c.Google.ChatModel}},
+		{key: "google.image_model", env: []string{"GOOGLE_IMAGE_MODEL"}, value: stringValue{&c.Google.ImageModel}},
+		{key: "firebase.database_url", env: []string{"FIREBASE_DATABASE_URL"}, flag: "firebase-db-url", usage: "Firebase Realtime Database URL", value: stringValue{&c.Firebase.DatabaseURL}},
+		{key: "storage.backend", env: []string{"STORAGE_BACKEND"}, value: stringValue{&c.Storage.Backend}},
+		{key: "storage.bucket", env: []string{"BASE_FACE_BUCKET"}, value: stringValue{&c.Storage.Bucket}},
+		{key: "storage.prefix", env: []string{"BASE_FACE_PREFIX"}, value: stringValue{&c.Storage.Prefix}},
+		{key: "storage.region", env: []string{"AWS_REGION"}, value: stringValue{&c.Storage.Region}},
+		{key: "storage.endpoint", env: []string{"S3_ENDPOINT"}, value: stringValue{&c.Storage.Endpoint}},
+		{key: "storage.path_style", env: []string{"S3_USE_PATH_STYLE"}, value: boolValue{&c.Storage.UsePathStyle}},
+		{key: "storage.public_base_url", env: []string{"S3_PUBLIC_BASE_URL"}, value: stringValue{&c.Storage.PublicBaseURL}},
+		{key: "storage.url_mode", env: []string{"S3_URL_MODE"}, value: stringValue{&c.Storage.URLMode}},
+		{key: "storage.presign_ttl", env: []string{"S3_PRESIGN_TTL"}, value: durationValue{&c.Storage.PresignTTL}},
+		{key: "storage.dir", env: []string{"STORAGE_DIR"}, value: stringValue{&c.Storage.Dir}},
+		{key: "storage.base_url", env: []string{"STORAGE_BASE_URL"}, value: stringValue{&c.Storage.BaseURL}},
+		{key: "storage.media_secret", env: []string{"STORAGE_MEDIA_SECRET"}, secret: true, value: stringValue{&c.Storage.MediaSecret}},
+		{key: "chat.role", flag: "role", usage: "Role used for user prompts", value: stringValue{&c.Chat.Role}},
+		{key: "chat.timeout", flag: "timeout", usage: "Per-request timeout", value: durationValue{&c.Chat.Timeout}},
+	}
+}
+
+// Loader registers the configuration flags on a flag set and builds the Config once the
+// flags are parsed.
+type Loader struct {
+	path  string
+	flags map[string]string
+}
+
+// NewLoader registers --config and one flag per flag-backed setting on fs.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{flags: make(map[string]string)}
+	fs.StringVar(&l.path, "config", "", "Path to a YAML config file (use "+envConfigFile+")")
+	for _, s := range Default().settings() {
+		if s.flag == "" {
+			continue
+		}
+		usage := s.usage
+		if len(s.env) > 0 {
+			usage += " (use " + s.env[0] + ")"
+		}
+		fs.Var(&flagValue{loader: l, name: s.flag, def: s.value.String()}, s.flag, usage)
+	}
+	return l
+}
+
+// Load applies the config file, the environment and the parsed flags over the defaults
+// and validates the result. Every problem found is reported, not just the first.
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	var errs []error
+	path := strings.TrimSpace(l.path)
+	if path == "" {
+		path = strings.TrimSpace(os.Getenv(envConfigFile))
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+		entries, err := parseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, e := range entries {
+			s, ok := byKey[e.key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s:%d: unknown setting %q", path, e.line, e.key))
+				continue
+			}
+			if s.secret && strings.Contains(e.value, redacted) {
+				errs = append(errs, fmt.Errorf("%s:%d: %s holds the %s placeholder printed by --print-config, not the secret", path, e.line, e.key, redacted))
+				continue
+			}
+			if err := s.value.Set(e.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %s: %v", path, e.line, e.key, err))
+			}
+		}
+	}
+	for _, s := range settings {
+		for _, name := range s.env {
+			raw, ok := os.LookupEnv(name)
+			if !ok || strings.TrimSpace(raw) == "" {
+				continue
+			}
+			if err := s.value.Set(raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s (from %s): %v", s.key, name, err))
+			}
+			break
+		}
+	}
+	for _, s := range settings {
+		raw, ok := l.flags[s.flag]
+		if !ok || s.flag == "" {
+			continue
+		}
+		if err := s.value.Set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %v", s.flag, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that every value is well formed.
+func (c *Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr: %v", err))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+	if c.Worker.Concurrency < 1 {
+		errs = append(errs, errors.New("worker.concurrency must be at least 1"))
+	}
+	if c.Chat.Timeout <= 0 {
+		errs = append(errs, errors.New("chat.timeout must be positive"))
+	}
+	if err := c.Mongo.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("mongo: %w", err))
+	}
+	backend := strings.ToLower(c.Storage.Backend)
+	switch backend {
+	case "", storage.BackendS3, storage.BackendLocal, storage.BackendMemory:
+	default:
+		errs = append(errs, fmt.Errorf("storage.backend: unknown backend %q (want %s, %s or %s)", c.Storage.Backend, storage.BackendS3, storage.BackendLocal, storage.BackendMemory))
+	}
+	if backend == storage.BackendLocal && c.Storage.Dir == "" {
+		errs = append(errs, errors.New("storage.dir is required for the local backend"))
+	}
+	switch mode := strings.ToLower(c.Storage.URLMode); mode {
+	case "", storage.URLModePublic:
+	case storage.URLModePresign, storage.URLModeProxy:
+		if backend != "" && backend != storage.BackendS3 {
+			errs = append(errs, fmt.Errorf("storage.url_mode %q only applies to the s3 backend", mode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.url_mode: unknown mode %q (want %s, %s or %s)", c.Storage.URLMode, storage.URLModePublic, storage.URLModePresign, storage.URLModeProxy))
+	}
+	if c.Storage.PresignTTL < 0 {
+		errs = append(errs, errors.New("storage.presign_ttl must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// RequireBackends checks the credentials needed by modes that talk to MongoDB and the
+// Generative Language API.
+func (c *Config) RequireBackends() error {
+	err := c.RequireMongo()
+	if c.Google.APIKey == "" {
+		err = errors.Join(err, errors.New("google.api_key is required (set GOOGLE_API_KEY)"))
+	}
+	return err
+}
+
+// RequireMongo checks that MongoDB can be reached: either mongo.uri or both Atlas
+// credentials must be set.
+func (c *Config) RequireMongo() error {
+	if c.Mongo.URI != "" {
+		return nil
+	}
+	var errs []error
+	if c.Mongo.Username == "" {
+		errs = append(errs, errors.New("mongo.username is required unless mongo.uri is set (set MONGO_DB_USERNAME or MONGO_URI)"))
+	}
+	if c.Mongo.Password == "" {
+		errs = append(errs, errors.New("mongo.password is required unless mongo.uri is set (set MONGO_DB_PASSWORD or MONGO_URI)"))
+	}
+	return errors.Join(errs...)
+}
+
+// Write prints the configuration as YAML. Secrets are redacted and commented out.
+func (c *Config) Write(w io.Writer) error {
+	var b strings.Builder
+	section := ""
+	for _, s := range c.settings() {
+		group, name, _ := strings.Cut(s.key, ".")
+		if group != section {
+			if section != "" {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "%s:\n", group)
+			section = group
+		}
+		value := s.value.String()
+		switch s.value.(type) {
+		case stringValue, addrValue:
+			value = strconv.Quote(value)
+		}
+		if s.secret {
+			// Secrets are commented out, so saved output loads without a placeholder
+			// standing in for the real value.
+			if value = s.value.String(); value != "" {
+				value = redact(value)
+			}
+			fmt.Fprintf(&b, "  # %s: %s\n", name, value)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", name, value)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// redact hides a secret. A URL keeps everything but the password, so the host of a
+// connection string stays visible.
+func redact(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.User == nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		return strings.Replace(u.String(), "xxxxx", redacted, 1)
+	}
+	return value
+}
+
+// AgentConfig returns the agent handler settings.
+func (c *Config) AgentConfig() agent.Config {
+	return agent.Config{
+		Mongo:   c.Mongo,
+		LLM:     llmservice.Config{APIKey: c.Google.APIKey, Model: c.Google.ChatModel},
+		Image:   imagegen.Config{APIKey: c.Google.APIKey, Model: c.Google.ImageModel},
+		Storage: c.Storage,
+	}
+}
+
+// UsersConfig returns the users handler settings.
+func (c *Config) UsersConfig() users.Config {
+	return users.Config{Mongo: c.Mongo}
+}
+
+// HTTPServerConfig returns the settings for the HTTP service.
+func (c *Config) HTTPServerConfig() httpserver.Config {
+	return httpserver.Config{
+		Addr:            c.Server.Addr,
+		Agent:           c.AgentConfig(),
+		Users:           c.UsersConfig(),
+		ProcessJobs:     c.Server.ProcessJobs,
+		Jobs:            c.jobs(),
+		ShutdownTimeout: c.Server.ShutdownTimeout,
+	}
+}
+
+// WorkerConfig returns the settings for a dedicated worker process.
+func (c *Config) WorkerConfig() worker.Config {
+	return worker.Config{Agent: c.AgentConfig(), Jobs: c.jobs()}
+}
+
+// ChatConfig returns the interactive chat CLI settings.
+func (c *Config) ChatConfig() chatcli.Config {
+	return chatcli.Config{
+		APIKey:              c.Google.APIKey,
+		Model:               c.Google.ChatModel,
+		Role:                c.Chat.Role,
+		Timeout:             c.Chat.Timeout,
+		FirebaseDatabaseURL: c.Firebase.DatabaseURL,
+	}
+}
+
+func (c *Config) jobs() jobs.WorkerConfig {
+	return jobs.WorkerConfig{Concurrency: c.Worker.Concurrency, DrainTimeout: c.Server.ShutdownTimeout}
+}
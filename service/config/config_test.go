@@ -0,0 +1,126 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := `# test config
+server:
+  addr: "127.0.0.1:8080"
+  shutdown_timeout: 10s   # drain quickly
+worker:
+  concurrency: 2
+google:
+  api_key: 'file-key'
+  chat_model: file-model
+storage:
+  backend: memory
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envConfigFile, path)
+	t.Setenv("PORT", "9090")
+	t.Setenv("GOOGLE_CHAT_MODEL", "env-model")
+	t.Setenv("MONGO_DB_PASSWORD", "hunter2")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	loader := NewLoader(fs)
+	if err := fs.Parse([]string{"--worker-concurrency", "8", "--model", "flag-model"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Server.Addr != ":9090" || cfg.Server.ShutdownTimeout != 10*time.Second {
+		t.Fatalf("server = %+v", cfg.Server)
+	}
+	if cfg.Worker.Concurrency != 8 || cfg.Google.ChatModel != "flag-model" || cfg.Google.APIKey != "file-key" {
+		t.Fatalf("worker = %+v, google = %+v", cfg.Worker, cfg.Google)
+	}
+	if cfg.Storage.Backend != "memory" || cfg.Mongo.Database != "buddy-agent" || cfg.Chat.Timeout != 2*time.Minute {
+		t.Fatalf("storage = %+v, mongo = %+v, chat = %+v", cfg.Storage, cfg.Mongo, cfg.Chat)
+	}
+
+	var out strings.Builder
+	if err := cfg.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "hunter2") || strings.Contains(out.String(), "file-key") {
+		t.Fatalf("secrets not redacted:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "  # password: <redacted>") {
+		t.Fatalf("missing commented-out password:\n%s", out.String())
+	}
+
+	// The printed config loads back with every setting but the secrets.
+	entries, err := parseYAML([]byte(out.String()))
+	if err != nil {
+		t.Fatalf("parse printed config: %v", err)
+	}
+	secrets := 0
+	for _, s := range cfg.settings() {
+		if s.secret {
+			secrets++
+		}
+	}
+	if len(entries) != len(cfg.settings())-secrets {
+		t.Fatalf("printed %d settings, want %d", len(entries), len(cfg.settings())-secrets)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "server:\n  addr: \":3000\"\n  timeout: 5s\nworker:\n  concurrency: lots\nstorage:\n  backend: ftp\nmongo:\n  password: \"<redacted>\"\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	loader := NewLoader(fs)
+	if err := fs.Parse([]string{"--config", path, "--shutdown-timeout", "soon"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loader.Load()
+	if err == nil {
+		t.Fatal("Load succeeded")
+	}
+	for _, want := range []string{
+		path + `:3: unknown setting "server.timeout"`,
+		path + `:5: worker.concurrency: invalid integer "lots"`,
+		`--shutdown-timeout: invalid duration "soon"`,
+		path + `:9: mongo.password holds the <redacted> placeholder`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	cfg := Default()
+	cfg.Storage.Backend = "ftp"
+	cfg.Worker.Concurrency = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "storage.backend") || !strings.Contains(err.Error(), "worker.concurrency") {
+		t.Fatalf("Validate = %v", err)
+	}
+}
+
+func TestParseYAMLRejectsMalformedFiles(t *testing.T) {
+	for name, doc := range map[string]string{
+		"under scalar": "server:\n  addr: x\n    port: 1\n",
+		"misaligned":   "server:\n    addr: x\n  port: 1\n",
+		"tab":          "server:\n\taddr: x\n",
+		"list":         "server:\n  - addr\n",
+		"bare scalar":  "just text\n",
+	} {
+		if _, err := parseYAML([]byte(doc)); err == nil {
+			t.Errorf("%s: parsed %q", name, doc)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// entry is one scalar from the config file, addressed by its dotted key path.
+type entry struct {
+	key   string
+	value string
+	line  int
+}
+
+// parseYAML decodes a config file into its scalars. The file is a tree of mappings; a
+// null or missing value is empty, and lists are rejected since no setting takes one.
+func parseYAML(data []byte) ([]entry, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	var entries []entry
+	if err := flattenYAML(doc.Content[0], "", &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func flattenYAML(node *yaml.Node, path string, entries *[]entry) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			if err := flattenYAML(node.Content[i+1], key, entries); err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml.ScalarNode:
+		if path == "" {
+			return fmt.Errorf("line %d: expected a mapping of settings", node.Line)
+		}
+		value := node.Value
+		if node.Tag == "!!null" {
+			value = ""
+		}
+		*entries = append(*entries, entry{key: path, value: value, line: node.Line})
+		return nil
+	default:
+		return fmt.Errorf("line %d: %s: lists are not supported", node.Line, path)
+	}
+}
+
+// The flag.Value adapters below let a setting be parsed the same way from the file,
+// the environment and the command line.
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = strings.TrimSpace(s); return nil }
+func (v stringValue) String() string     { return *v.p }
+
+// addrValue is a listen address; a bare port such as "8080" means ":8080".
+type addrValue struct{ p *string }
+
+func (v addrValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.Contains(s, ":") {
+		s = ":" + s
+	}
+	*v.p = s
+	return nil
+}
+func (v addrValue) String() string { return *v.p }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", s)
+	}
+	*v.p = b
+	return nil
+}
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", s)
+	}
+	*v.p = n
+	return nil
+}
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (want e.g. 30s or 2m)", s)
+	}
+	*v.p = d
+	return nil
+}
+func (v durationValue) String() string { return (*v.p).String() }
+
+// flagValue records a command-line value so Load can apply it after the file and the
+// environment.
+type flagValue struct {
+	loader *Loader
+	name   string
+	def    string
+}
+
+func (f *flagValue) Set(s string) error { f.loader.flags[f.name] = s; return nil }
+func (f *flagValue) String() string {
+	if f == nil || f.loader == nil {
+		return ""
+	}
+	if v, ok := f.loader.flags[f.name]; ok {
+		return v
+	}
+	return f.def
+}
+
+// IsBoolFlag lets boolean settings be given as a bare --flag.
+func (f *flagValue) IsBoolFlag() bool { return f.def == "true" || f.def == "false" }
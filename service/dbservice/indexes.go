@@ -0,0 +1,202 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index declares one index. Indexes are matched to the database by Name, so renaming an
+// index in a registry creates a new one and reports the old one as extra.
+type Index struct {
+	Name   string
+	Keys   bson.D
+	Unique bool
+	// TTL makes a single-field index on a date a TTL index: MongoDB removes documents
+	// ExpireAfter past that date, or at the date itself when ExpireAfter is zero.
+	TTL         bool
+	ExpireAfter time.Duration
+}
+
+// CollectionIndexes lists the indexes a collection must have.
+type CollectionIndexes struct {
+	Collection string
+	Indexes    []Index
+}
+
+// IndexOptions controls EnsureIndexes.
+type IndexOptions struct {
+	// DryRun reports drift without changing anything.
+	DryRun bool
+	// Replace drops and recreates indexes whose keys or options differ from the
+	// registry. Without it such indexes are only reported, since rebuilding an index
+	// on a large collection is expensive.
+	Replace bool
+}
+
+// IndexReport describes how the database differs from the registry. Entries are
+// "collection.index".
+type IndexReport struct {
+	// Created lists missing indexes, created unless the run was a dry run.
+	Created []string
+	// Changed lists indexes whose definition differs, replaced when Replace is set.
+	Changed []string
+	// Extra lists indexes the registry does not declare. They are never dropped.
+	Extra []string
+	// Failed lists indexes that could not be created, such as a unique index over
+	// duplicate values, with the reason.
+	Failed []string
+}
+
+// Drift reports whether the database differed from the registry.
+func (r IndexReport) Drift() bool {
+	return len(r.Created)+len(r.Changed)+len(r.Extra)+len(r.Failed) > 0
+}
+
+// existingIndex is the part of a listIndexes result the registry can declare.
+type existingIndex struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+}
+
+// EnsureIndexes reconciles the database with registry. Index build failures are
+// collected in the report; the returned error is for failures to inspect the database
+// or any entry in Failed.
+func (s *Service) EnsureIndexes(ctx context.Context, registry []CollectionIndexes, opts IndexOptions) (IndexReport, error) {
+	var report IndexReport
+	db := s.Database()
+	if db == nil {
+		return report, fmt.Errorf("mongo service not initialized")
+	}
+	for _, coll := range registry {
+		existing, err := listIndexes(ctx, db.Collection(coll.Collection))
+		if err != nil {
+			return report, err
+		}
+		declared := make(map[string]bool, len(coll.Indexes))
+		for _, idx := range coll.Indexes {
+			declared[idx.Name] = true
+			name := coll.Collection + "." + idx.Name
+			current, ok := existing[idx.Name]
+			switch {
+			case !ok:
+				report.Created = append(report.Created, name)
+			case idx.matches(current):
+				continue
+			default:
+				report.Changed = append(report.Changed, name)
+				if opts.DryRun || !opts.Replace {
+					continue
+				}
+				if _, err := db.Collection(coll.Collection).Indexes().DropOne(ctx, idx.Name); err != nil {
+					report.Failed = append(report.Failed, fmt.Sprintf("%s: drop: %v", name, err))
+					continue
+				}
+			}
+			if opts.DryRun {
+				continue
+			}
+			if _, err := db.Collection(coll.Collection).Indexes().CreateOne(ctx, idx.model()); err != nil {
+				report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+		names := make([]string, 0, len(existing))
+		for name := range existing {
+			if name != "_id_" && !declared[name] {
+				names = append(names, coll.Collection+"."+name)
+			}
+		}
+		slices.Sort(names)
+		report.Extra = append(report.Extra, names...)
+	}
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("%d index(es) could not be built", len(report.Failed))
+	}
+	return report, nil
+}
+
+func listIndexes(ctx context.Context, collection *mongo.Collection) (map[string]existingIndex, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		// A collection that does not exist yet has no indexes.
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceNotFound" {
+			return map[string]existingIndex{}, nil
+		}
+		return nil, fmt.Errorf("list %s indexes: %w", collection.Name(), err)
+	}
+	var indexes []existingIndex
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, fmt.Errorf("list %s indexes: %w", collection.Name(), err)
+	}
+	existing := make(map[string]existingIndex, len(indexes))
+	for _, idx := range indexes {
+		existing[idx.Name] = idx
+	}
+	return existing, nil
+}
+
+func (idx Index) model() mongo.IndexModel {
+	opts := options.Index().SetName(idx.Name)
+	if idx.Unique {
+		opts.SetUnique(true)
+	}
+	if idx.TTL {
+		opts.SetExpireAfterSeconds(int32(idx.ExpireAfter / time.Second))
+	}
+	return mongo.IndexModel{Keys: idx.Keys, Options: opts}
+}
+
+func (idx Index) matches(current existingIndex) bool {
+	if idx.Unique != current.Unique || len(idx.Keys) != len(current.Key) {
+		return false
+	}
+	for i, key := range idx.Keys {
+		if key.Key != current.Key[i].Key || fmt.Sprint(key.Value) != fmt.Sprint(current.Key[i].Value) {
+			return false
+		}
+	}
+	if !idx.TTL {
+		return current.ExpireAfterSeconds == nil
+	}
+	return current.ExpireAfterSeconds != nil && *current.ExpireAfterSeconds == int64(idx.ExpireAfter/time.Second)
+}
+
+// Lines describes the report one index per line, for logs and command output.
+func (r IndexReport) Lines() []string {
+	var lines []string
+	for _, group := range []struct {
+		label string
+		names []string
+	}{
+		{"missing", r.Created},
+		{"changed", r.Changed},
+		{"extra", r.Extra},
+		{"failed", r.Failed},
+	} {
+		for _, name := range group.names {
+			lines = append(lines, group.label+" "+name)
+		}
+	}
+	return lines
+}
+
+// LogIndexReport logs what a startup EnsureIndexes run found. Startup keeps going on
+// failure; the indexes can be repaired with the --ensure-indexes command.
+func LogIndexReport(report IndexReport, err error) {
+	for _, line := range report.Lines() {
+		log.Printf("mongo index %s", line)
+	}
+	if err != nil {
+		log.Printf("ensure mongo indexes: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package dbservice
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexMatches(t *testing.T) {
+	ttl := int64(3600)
+	existing := existingIndex{
+		Name: "expires",
+		Key:  bson.D{{Key: "expires_at", Value: int32(1)}},
+	}
+	idx := Index{Name: "expires", Keys: bson.D{{Key: "expires_at", Value: 1}}}
+	if !idx.matches(existing) {
+		t.Fatal("identical keys did not match")
+	}
+	idx.TTL, idx.ExpireAfter = true, time.Hour
+	if idx.matches(existing) {
+		t.Fatal("TTL index matched a plain index")
+	}
+	existing.ExpireAfterSeconds = &ttl
+	if !idx.matches(existing) {
+		t.Fatal("TTL index did not match")
+	}
+	idx.Unique = true
+	if idx.matches(existing) {
+		t.Fatal("unique index matched a non-unique one")
+	}
+	idx = Index{Name: "expires", Keys: bson.D{{Key: "expires_at", Value: -1}}, TTL: true, ExpireAfter: time.Hour}
+	if idx.matches(existing) {
+		t.Fatal("descending index matched an ascending one")
+	}
+}
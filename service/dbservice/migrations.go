@@ -0,0 +1,289 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection = "migrations"
+	migrationLockID      = "migration_lock"
+	// migrationLease is how long a lock outlives a replica that died while migrating.
+	migrationLease      = 2 * time.Minute
+	migrationLockPoll   = 2 * time.Second
+	migrationDBTimeout  = 5 * time.Second
+	migrationLockRenews = 3
+)
+
+// ErrMigrationLocked is returned when another process holds the migration lock.
+var ErrMigrationLocked = errors.New("migrations are locked by another process")
+
+// errMigrationLeaseLost stops a running migration whose lock could not be renewed.
+var errMigrationLeaseLost = errors.New("migration lock lease lost")
+
+// Migration is one versioned, forward-only schema change.
+type Migration struct {
+	// Version orders migrations and is recorded once the migration succeeds.
+	Version int
+	Name    string
+	// Up applies the change and returns how many documents it touched. A process that
+	// dies halfway leaves the migration unrecorded, so Up must be safe to run again.
+	Up func(ctx context.Context, db *mongo.Database) (int64, error)
+	// Count reports how many documents Up would touch, for dry runs.
+	Count func(ctx context.Context, db *mongo.Database) (int64, error)
+}
+
+// MigrateOptions controls Migrate.
+type MigrateOptions struct {
+	// DryRun reports the pending migrations without taking the lock or changing data.
+	DryRun bool
+	// Wait blocks until the lock is free instead of returning ErrMigrationLocked.
+	Wait bool
+}
+
+// MigrationStep describes one migration of a Migrate run.
+type MigrationStep struct {
+	Version   int
+	Name      string
+	Documents int64
+	Duration  time.Duration
+}
+
+// MigrationReport summarises a Migrate run.
+type MigrationReport struct {
+	// Applied lists the migrations this run applied.
+	Applied []MigrationStep
+	// Pending lists the migrations a dry run would apply.
+	Pending []MigrationStep
+}
+
+// appliedMigration is the record stored in the migrations collection.
+type appliedMigration struct {
+	Version    int       `bson:"_id"`
+	Name       string    `bson:"name"`
+	Documents  int64     `bson:"documents"`
+	AppliedAt  time.Time `bson:"applied_at"`
+	DurationMS int64     `bson:"duration_ms"`
+}
+
+// Migrate applies the migrations that are not recorded in the migrations collection, in
+// version order. A lease-based lock in the same collection makes sure only one replica
+// migrates at a time; the lease is renewed while migrations run.
+func (s *Service) Migrate(ctx context.Context, migrations []Migration, opts MigrateOptions) (MigrationReport, error) {
+	var report MigrationReport
+	db := s.Database()
+	if db == nil {
+		return report, fmt.Errorf("mongo service not initialized")
+	}
+	migrations, err := sortMigrations(migrations)
+	if err != nil {
+		return report, err
+	}
+	collection := db.Collection(migrationsCollection)
+
+	if opts.DryRun {
+		applied, err := appliedMigrations(ctx, collection)
+		if err != nil {
+			return report, err
+		}
+		for _, m := range pendingMigrations(migrations, applied) {
+			step := MigrationStep{Version: m.Version, Name: m.Name, Documents: -1}
+			if m.Count != nil {
+				n, err := m.Count(ctx, db)
+				if err != nil {
+					return report, fmt.Errorf("count migration %d %s: %w", m.Version, m.Name, err)
+				}
+				step.Documents = n
+			}
+			report.Pending = append(report.Pending, step)
+		}
+		return report, nil
+	}
+
+	owner := lockOwner()
+	if err := acquireMigrationLock(ctx, collection, owner, opts.Wait); err != nil {
+		return report, err
+	}
+	// lockCtx ends when the lease is lost, so Up stops before another replica can take
+	// the lock and migrate at the same time.
+	lockCtx, stopRenewing := context.WithCancelCause(ctx)
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+		renewMigrationLock(lockCtx, collection, owner, stopRenewing)
+	}()
+	defer func() {
+		stopRenewing(nil)
+		<-renewDone
+		releaseCtx, cancel := context.WithTimeout(context.Background(), migrationDBTimeout)
+		defer cancel()
+		_, _ = collection.DeleteOne(releaseCtx, bson.M{"_id": migrationLockID, "owner": owner})
+	}()
+
+	// Read the applied set under the lock: another replica may have just finished.
+	applied, err := appliedMigrations(ctx, collection)
+	if err != nil {
+		return report, err
+	}
+	for _, m := range pendingMigrations(migrations, applied) {
+		start := time.Now()
+		n, err := m.Up(lockCtx, db)
+		if lost := context.Cause(lockCtx); errors.Is(lost, errMigrationLeaseLost) {
+			err = lost
+		}
+		if err != nil {
+			return report, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		step := MigrationStep{Version: m.Version, Name: m.Name, Documents: n, Duration: time.Since(start)}
+		record := appliedMigration{
+			Version:    m.Version,
+			Name:       m.Name,
+			Documents:  n,
+			AppliedAt:  time.Now().UTC(),
+			DurationMS: step.Duration.Milliseconds(),
+		}
+		dbCtx, cancel := context.WithTimeout(ctx, migrationDBTimeout)
+		_, err = collection.InsertOne(dbCtx, record)
+		cancel()
+		if err != nil {
+			return report, fmt.Errorf("record migration %d %s: %w", m.Version, m.Name, err)
+		}
+		report.Applied = append(report.Applied, step)
+	}
+	return report, nil
+}
+
+// sortMigrations returns migrations in version order, rejecting invalid and duplicate
+// versions.
+func sortMigrations(migrations []Migration) ([]Migration, error) {
+	migrations = slices.Clone(migrations)
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i, m := range migrations {
+		if m.Version <= 0 || m.Up == nil {
+			return nil, fmt.Errorf("migration %q needs a positive version and an Up function", m.Name)
+		}
+		if i > 0 && migrations[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", migrations[i-1].Name, m.Name, m.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// appliedMigrations loads the versions recorded in the collection.
+func appliedMigrations(ctx context.Context, collection *mongo.Collection) (map[int]bool, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, migrationDBTimeout)
+	defer cancel()
+	cursor, err := collection.Find(dbCtx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, fmt.Errorf("load applied migrations: %w", err)
+	}
+	var records []appliedMigration
+	if err := cursor.All(dbCtx, &records); err != nil {
+		return nil, fmt.Errorf("load applied migrations: %w", err)
+	}
+	applied := make(map[int]bool, len(records))
+	for _, r := range records {
+		applied[r.Version] = true
+	}
+	return applied, nil
+}
+
+// pendingMigrations returns the migrations whose version is not applied, keeping their
+// order.
+func pendingMigrations(migrations []Migration, applied map[int]bool) []Migration {
+	var pending []Migration
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// acquireMigrationLock takes the lock document, or an expired one, for owner.
+func acquireMigrationLock(ctx context.Context, collection *mongo.Collection, owner string, wait bool) error {
+	for {
+		now := time.Now().UTC()
+		filter := bson.M{"_id": migrationLockID, "expires_at": bson.M{"$lt": now}}
+		update := bson.M{"$set": bson.M{"owner": owner, "acquired_at": now, "expires_at": now.Add(migrationLease)}}
+		dbCtx, cancel := context.WithTimeout(ctx, migrationDBTimeout)
+		_, err := collection.UpdateOne(dbCtx, filter, update, options.Update().SetUpsert(true))
+		cancel()
+		switch {
+		case err == nil:
+			return nil
+		case !mongo.IsDuplicateKeyError(err):
+			return fmt.Errorf("acquire migration lock: %w", err)
+		case !wait:
+			return ErrMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockPoll):
+		}
+	}
+}
+
+// renewMigrationLock extends the lease a few times per lease period until ctx is done.
+// It calls lost once the lock turns out to belong to someone else, or once renewals have
+// failed for a whole lease period.
+func renewMigrationLock(ctx context.Context, collection *mongo.Collection, owner string, lost context.CancelCauseFunc) {
+	ticker := time.NewTicker(migrationLease / migrationLockRenews)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			dbCtx, cancel := context.WithTimeout(ctx, migrationDBTimeout)
+			update := bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(migrationLease)}}
+			result, err := collection.UpdateOne(dbCtx, bson.M{"_id": migrationLockID, "owner": owner}, update)
+			cancel()
+			switch {
+			case err == nil && result.MatchedCount == 0:
+				lost(errMigrationLeaseLost)
+				return
+			case err == nil:
+				renewed = time.Now()
+			case time.Since(renewed) >= migrationLease:
+				lost(fmt.Errorf("%w: %v", errMigrationLeaseLost, err))
+				return
+			}
+		}
+	}
+}
+
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil || strings.TrimSpace(host) == "" {
+		host = "migrator"
+	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// Lines describes the report one migration per line, for logs and command output.
+func (r MigrationReport) Lines() []string {
+	var lines []string
+	for _, step := range r.Pending {
+		docs := "unknown number of documents"
+		if step.Documents >= 0 {
+			docs = fmt.Sprintf("%d documents", step.Documents)
+		}
+		lines = append(lines, fmt.Sprintf("pending %d %s (%s)", step.Version, step.Name, docs))
+	}
+	for _, step := range r.Applied {
+		lines = append(lines, fmt.Sprintf("applied %d %s (%d documents in %s)", step.Version, step.Name, step.Documents, step.Duration.Round(time.Millisecond)))
+	}
+	return lines
+}
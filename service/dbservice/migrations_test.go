@@ -0,0 +1,71 @@
+package dbservice
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func noopUp(context.Context, *mongo.Database) (int64, error) { return 0, nil }
+
+func TestSortMigrations(t *testing.T) {
+	sorted, err := sortMigrations([]Migration{
+		{Version: 3, Name: "c", Up: noopUp},
+		{Version: 1, Name: "a", Up: noopUp},
+		{Version: 2, Name: "b", Up: noopUp},
+	})
+	if err != nil {
+		t.Fatalf("sortMigrations: %v", err)
+	}
+	var names []string
+	for _, m := range sorted {
+		names = append(names, m.Name)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(names, want) {
+		t.Fatalf("order = %q, want %q", names, want)
+	}
+
+	for name, migrations := range map[string][]Migration{
+		"duplicate version": {{Version: 2, Name: "x", Up: noopUp}, {Version: 1, Name: "a", Up: noopUp}, {Version: 2, Name: "y", Up: noopUp}},
+		"zero version":      {{Version: 0, Name: "zero", Up: noopUp}},
+		"missing up":        {{Version: 1, Name: "empty"}},
+	} {
+		if _, err := sortMigrations(migrations); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	_, err = sortMigrations([]Migration{{Version: 2, Name: "x", Up: noopUp}, {Version: 2, Name: "y", Up: noopUp}})
+	if err == nil || !strings.Contains(err.Error(), "share version 2") {
+		t.Fatalf("duplicate error = %v", err)
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations := []Migration{{Version: 1, Name: "a"}, {Version: 2, Name: "b"}, {Version: 3, Name: "c"}}
+	pending := pendingMigrations(migrations, map[int]bool{1: true, 3: true, 7: true})
+	if len(pending) != 1 || pending[0].Version != 2 {
+		t.Fatalf("pending = %+v, want only version 2", pending)
+	}
+	if pending := pendingMigrations(migrations, nil); len(pending) != 3 {
+		t.Fatalf("nothing applied: %d pending, want 3", len(pending))
+	}
+}
+
+func TestMigrationReportLines(t *testing.T) {
+	report := MigrationReport{
+		Pending: []MigrationStep{{Version: 2, Name: "b", Documents: 5}, {Version: 3, Name: "c", Documents: -1}},
+		Applied: []MigrationStep{{Version: 1, Name: "a", Documents: 12, Duration: 1500 * time.Microsecond}},
+	}
+	want := []string{
+		"pending 2 b (5 documents)",
+		"pending 3 c (unknown number of documents)",
+		"applied 1 a (12 documents in 2ms)",
+	}
+	if got := report.Lines(); !slices.Equal(got, want) {
+		t.Fatalf("Lines = %q, want %q", got, want)
+	}
+}
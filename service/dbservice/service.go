@@ -2,51 +2,109 @@ package dbservice
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 const (
+	envMongoURI      = "MONGO_URI"
 	envMongoUsername = "MONGO_DB_USERNAME"
 	envMongoPassword = "MONGO_DB_PASSWORD"
 	clusterURIFormat = "mongodb+srv://%s:%s@cluster0.2qidkde.mongodb.net/"
 	connectTimeout   = 10 * time.Second
+	// DefaultDatabase is the database used when Config.Database is empty.
+	DefaultDatabase = "buddy-agent"
 )
 
-// Config configures how the MongoDB client is created.
+// ErrNoTransactions is returned by WithTransaction on a standalone server.
+var ErrNoTransactions = errors.New("mongo deployment does not support transactions")
+
+// Config configures how the MongoDB client is created. Zero values keep the options given
+// in URI, or the driver defaults.
 type Config struct {
+	// URI is a full connection string, such as mongodb://localhost:27017 for an
+	// unauthenticated local server or a replica set seed list. When empty, the Atlas
+	// cluster URI is built from Username and Password.
+	URI string
+	// Username and Password authenticate against the Atlas cluster, or against URI when
+	// it carries no credentials of its own.
 	Username string
 	Password string
-	URI      string
+	// Database is the database the services store their collections in.
+	Database string
+
+	MaxPoolSize            int
+	MinPoolSize            int
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	// OperationTimeout bounds each operation, including retries.
+	OperationTimeout time.Duration
+
+	// ReadConcern is a read concern level: local, available, majority, linearizable or
+	// snapshot.
+	ReadConcern string
+	// ReadPreference is a read preference mode such as primary or secondaryPreferred.
+	ReadPreference string
+	// WriteConcern is "majority" or the number of members that must acknowledge a write.
+	WriteConcern string
+
+	// TLS turns TLS on; when false the URI decides.
+	TLS bool
+	// TLSCAFile is a PEM file with the certificate authorities to trust.
+	TLSCAFile string
+	// TLSCertificateKeyFile is a PEM file with the client certificate and its key.
+	TLSCertificateKeyFile string
+	// TLSInsecure skips server certificate verification. Only use it for development.
+	TLSInsecure bool
 }
 
 // Service provides access to the MongoDB client connection.
 type Service struct {
-	client *mongo.Client
+	client   *mongo.Client
+	database string
+	// transactions is true for replica sets and sharded clusters; standalone servers
+	// cannot run multi-document transactions.
+	transactions bool
 }
 
+// New creates a MongoDB client from the MONGO_URI, or MONGO_DB_USERNAME and
+// MONGO_DB_PASSWORD, environment variables. Services receive their settings from the
+// config package and use NewWithConfig instead.
 func New(ctx context.Context) (*Service, error) {
-	username := strings.TrimSpace(os.Getenv(envMongoUsername))
-	password := strings.TrimSpace(os.Getenv(envMongoPassword))
-	if username == "" {
-		return nil, fmt.Errorf("%s is required", envMongoUsername)
-	}
-	if password == "" {
-		return nil, fmt.Errorf("%s is required", envMongoPassword)
-	}
+	return NewWithConfig(ctx, Config{
+		URI:      os.Getenv(envMongoURI),
+		Username: os.Getenv(envMongoUsername),
+		Password: os.Getenv(envMongoPassword),
+	})
+}
 
-	uri := fmt.Sprintf(clusterURIFormat, url.QueryEscape(username), url.QueryEscape(password))
+// NewWithConfig creates a MongoDB client from cfg and checks that the server answers.
+func NewWithConfig(ctx context.Context, cfg Config) (*Service, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	clientOpts := options.Client().ApplyURI(uri)
-	clientOpts.SetServerAPIOptions(options.ServerAPI(options.ServerAPIVersion1))
+	clientOpts, err := cfg.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
 
-	connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	timeout := max(connectTimeout, cfg.ConnectTimeout, cfg.ServerSelectionTimeout)
+	connectCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	client, err := mongo.Connect(connectCtx, clientOpts)
@@ -58,51 +116,184 @@ func New(ctx context.Context) (*Service, error) {
 		return nil, fmt.Errorf("ping mongo: %w", err)
 	}
 
-	return &Service{client: client}, nil
-}
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(connectCtx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("describe mongo deployment: %w", err)
+	}
 
-// New creates a MongoDB client using credentials from the provided config or environment variables.
-func NewWithConfig(ctx context.Context, cfg Config) (*Service, error) {
-	if ctx == nil {
-		ctx = context.Background()
+	database := strings.TrimSpace(cfg.Database)
+	if database == "" {
+		database = DefaultDatabase
 	}
+	return &Service{
+		client:       client,
+		database:     database,
+		transactions: hello.SetName != "" || hello.Msg == "isdbgrid",
+	}, nil
+}
 
-	username := strings.TrimSpace(cfg.Username)
-	if username == "" {
-		username = strings.TrimSpace(os.Getenv(envMongoUsername))
+// Validate checks the settings without contacting the server. Credentials are only
+// required when no URI is given.
+func (c Config) Validate() error {
+	var errs []error
+	if uri := strings.TrimSpace(c.URI); uri != "" && !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		errs = append(errs, fmt.Errorf("uri must start with mongodb:// or mongodb+srv://"))
+	}
+	if c.MaxPoolSize < 0 || c.MinPoolSize < 0 {
+		errs = append(errs, fmt.Errorf("pool sizes must not be negative"))
 	}
-	password := strings.TrimSpace(cfg.Password)
-	if password == "" {
-		password = strings.TrimSpace(os.Getenv(envMongoPassword))
+	if c.MaxPoolSize > 0 && c.MinPoolSize > c.MaxPoolSize {
+		errs = append(errs, fmt.Errorf("min pool size %d exceeds max pool size %d", c.MinPoolSize, c.MaxPoolSize))
 	}
-	if username == "" {
-		return nil, fmt.Errorf("%s is required", envMongoUsername)
+	if c.ConnectTimeout < 0 || c.ServerSelectionTimeout < 0 || c.OperationTimeout < 0 {
+		errs = append(errs, fmt.Errorf("timeouts must not be negative"))
 	}
-	if password == "" {
-		return nil, fmt.Errorf("%s is required", envMongoPassword)
+	if _, err := c.readConcern(); err != nil {
+		errs = append(errs, err)
 	}
+	if _, err := c.readPreference(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.writeConcern(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.tlsConfig(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
 
-	uri := strings.TrimSpace(cfg.URI)
-	if uri == "" {
+// ClientOptions builds the driver options for cfg. Settings made here override the
+// same options given in URI.
+func (c Config) ClientOptions() (*options.ClientOptions, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	username := strings.TrimSpace(c.Username)
+	password := strings.TrimSpace(c.Password)
+	uri := strings.TrimSpace(c.URI)
+	atlas := uri == ""
+	if atlas {
+		if username == "" {
+			return nil, fmt.Errorf("mongo username is required")
+		}
+		if password == "" {
+			return nil, fmt.Errorf("mongo password is required")
+		}
 		uri = fmt.Sprintf(clusterURIFormat, url.QueryEscape(username), url.QueryEscape(password))
 	}
 
 	clientOpts := options.Client().ApplyURI(uri)
-	clientOpts.SetServerAPIOptions(options.ServerAPI(options.ServerAPIVersion1))
+	if err := clientOpts.Validate(); err != nil {
+		return nil, fmt.Errorf("mongo uri: %w", err)
+	}
+	if atlas {
+		clientOpts.SetServerAPIOptions(options.ServerAPI(options.ServerAPIVersion1))
+	} else if clientOpts.Auth == nil && username != "" {
+		clientOpts.SetAuth(options.Credential{Username: username, Password: password})
+	}
 
-	connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
-	defer cancel()
+	if c.MaxPoolSize > 0 {
+		clientOpts.SetMaxPoolSize(uint64(c.MaxPoolSize))
+	}
+	if c.MinPoolSize > 0 {
+		clientOpts.SetMinPoolSize(uint64(c.MinPoolSize))
+	}
+	if c.ConnectTimeout > 0 {
+		clientOpts.SetConnectTimeout(c.ConnectTimeout)
+	}
+	if c.ServerSelectionTimeout > 0 {
+		clientOpts.SetServerSelectionTimeout(c.ServerSelectionTimeout)
+	}
+	if c.OperationTimeout > 0 {
+		clientOpts.SetTimeout(c.OperationTimeout)
+	}
+	if rc, _ := c.readConcern(); rc != nil {
+		clientOpts.SetReadConcern(rc)
+	}
+	if rp, _ := c.readPreference(); rp != nil {
+		clientOpts.SetReadPreference(rp)
+	}
+	if wc, _ := c.writeConcern(); wc != nil {
+		clientOpts.SetWriteConcern(wc)
+	}
+	if tlsCfg, _ := c.tlsConfig(); tlsCfg != nil {
+		clientOpts.SetTLSConfig(tlsCfg)
+	}
+	return clientOpts, nil
+}
 
-	client, err := mongo.Connect(connectCtx, clientOpts)
+func (c Config) readConcern() (*readconcern.ReadConcern, error) {
+	switch level := strings.TrimSpace(c.ReadConcern); level {
+	case "":
+		return nil, nil
+	case "local", "available", "majority", "linearizable", "snapshot":
+		return &readconcern.ReadConcern{Level: level}, nil
+	default:
+		return nil, fmt.Errorf("unknown read concern %q", level)
+	}
+}
+
+func (c Config) readPreference() (*readpref.ReadPref, error) {
+	name := strings.TrimSpace(c.ReadPreference)
+	if name == "" {
+		return nil, nil
+	}
+	mode, err := readpref.ModeFromString(name)
 	if err != nil {
-		return nil, fmt.Errorf("connect to mongo: %w", err)
+		return nil, fmt.Errorf("unknown read preference %q", name)
 	}
-	if err := client.Ping(connectCtx, nil); err != nil {
-		_ = client.Disconnect(context.Background())
-		return nil, fmt.Errorf("ping mongo: %w", err)
+	return readpref.New(mode)
+}
+
+func (c Config) writeConcern() (*writeconcern.WriteConcern, error) {
+	w := strings.TrimSpace(c.WriteConcern)
+	if w == "" {
+		return nil, nil
+	}
+	if w == "majority" {
+		return writeconcern.Majority(), nil
+	}
+	n, err := strconv.Atoi(w)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("write concern must be \"majority\" or a member count, got %q", w)
 	}
+	return &writeconcern.WriteConcern{W: n}, nil
+}
 
-	return &Service{client: client}, nil
+// tlsConfig returns nil when no TLS setting is made, leaving TLS to the URI.
+func (c Config) tlsConfig() (*tls.Config, error) {
+	if !c.TLS && c.TLSCAFile == "" && c.TLSCertificateKeyFile == "" && !c.TLSInsecure {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: c.TLSInsecure}
+	if c.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read tls ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls ca file %s contains no certificates", c.TLSCAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.TLSCertificateKeyFile != "" {
+		pem, err := os.ReadFile(c.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read tls certificate key file: %w", err)
+		}
+		cert, err := tls.X509KeyPair(pem, pem)
+		if err != nil {
+			return nil, fmt.Errorf("load tls certificate key file: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
 }
 
 // Client returns the underlying mongo.Client instance.
@@ -113,6 +304,41 @@ func (s *Service) Client() *mongo.Client {
 	return s.client
 }
 
+// Database returns the configured database.
+func (s *Service) Database() *mongo.Database {
+	if s == nil || s.client == nil {
+		return nil
+	}
+	return s.client.Database(s.database)
+}
+
+// Transactions reports whether the deployment supports multi-document transactions.
+// Standalone servers, typical in development, do not.
+func (s *Service) Transactions() bool {
+	return s != nil && s.transactions
+}
+
+// WithTransaction runs fn in a multi-document transaction, retrying it on transient
+// errors as the driver recommends. fn must do all its work through the context it is
+// given and be safe to run more than once.
+func (s *Service) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("mongo service not initialized")
+	}
+	if !s.transactions {
+		return ErrNoTransactions
+	}
+	session, err := s.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("start mongo session: %w", err)
+	}
+	defer session.EndSession(context.Background())
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
 // Close closes the MongoDB client connection.
 func (s *Service) Close(ctx context.Context) error {
 	if s == nil || s.client == nil {
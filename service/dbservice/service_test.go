@@ -13,10 +13,11 @@ import (
 
 func TestInsertDocument(t *testing.T) {
 	loadEnvFile(t, ".env")
+	uri := strings.TrimSpace(os.Getenv(envMongoURI))
 	username := strings.TrimSpace(os.Getenv(envMongoUsername))
 	password := strings.TrimSpace(os.Getenv(envMongoPassword))
-	if username == "" || password == "" {
-		t.Fatalf("%s and %s must be set in environment or .env", envMongoUsername, envMongoPassword)
+	if uri == "" && (username == "" || password == "") {
+		t.Fatalf("%s, or %s and %s, must be set in environment or .env", envMongoURI, envMongoUsername, envMongoPassword)
 	}
 
 	svc, err := New(context.Background())
@@ -41,6 +42,47 @@ func TestInsertDocument(t *testing.T) {
 	}
 }
 
+func TestClientOptions(t *testing.T) {
+	opts, err := Config{
+		URI:              "mongodb://localhost:27017,localhost:27018/?replicaSet=rs0",
+		MaxPoolSize:      20,
+		OperationTimeout: 5 * time.Second,
+		ReadConcern:      "majority",
+		ReadPreference:   "secondaryPreferred",
+		WriteConcern:     "2",
+	}.ClientOptions()
+	if err != nil {
+		t.Fatalf("ClientOptions: %v", err)
+	}
+	if opts.Auth != nil || opts.ServerAPIOptions != nil {
+		t.Fatalf("unauthenticated URI got auth %+v, server api %+v", opts.Auth, opts.ServerAPIOptions)
+	}
+	if *opts.ReplicaSet != "rs0" || *opts.MaxPoolSize != 20 || *opts.Timeout != 5*time.Second {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	if opts.ReadConcern.Level != "majority" || opts.WriteConcern.W != 2 || opts.ReadPreference.Mode().String() != "secondaryPreferred" {
+		t.Fatalf("unexpected concerns %+v %+v %v", opts.ReadConcern, opts.WriteConcern, opts.ReadPreference)
+	}
+
+	opts, err = Config{URI: "mongodb://db.internal", Username: "svc", Password: "secret"}.ClientOptions()
+	if err != nil {
+		t.Fatalf("ClientOptions: %v", err)
+	}
+	if opts.Auth == nil || opts.Auth.Username != "svc" {
+		t.Fatalf("credentials not applied: %+v", opts.Auth)
+	}
+
+	if _, err := (Config{}).ClientOptions(); err == nil {
+		t.Fatal("ClientOptions without URI or credentials succeeded")
+	}
+	err = Config{URI: "localhost:27017", ReadConcern: "eventual", WriteConcern: "all", MinPoolSize: 5, MaxPoolSize: 1}.Validate()
+	for _, want := range []string{"uri", "read concern", "write concern", "pool size"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want mention of %q", err, want)
+		}
+	}
+}
+
 func loadEnvFile(t *testing.T, name string) {
 	t.Helper()
 	path, err := findFileUpwards(name)
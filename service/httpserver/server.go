@@ -4,16 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"buddy-agent/service/agent"
+	"buddy-agent/service/dbservice"
+	"buddy-agent/service/jobs"
 	"buddy-agent/service/users"
 )
 
-const apiVersionPrefix = "/api/v1"
+const (
+	apiVersionPrefix       = "/api/v1"
+	defaultAddr            = ":3000"
+	defaultShutdownTimeout = 5 * time.Second
+)
 
 func apiVersionPath(path string) string {
 	path = strings.TrimPrefix(path, "/")
@@ -23,17 +29,17 @@ func apiVersionPath(path string) string {
 	return apiVersionPrefix + "/" + path
 }
 
-func servicePort() string {
-	port := strings.TrimSpace(os.Getenv("PORT"))
-	if port == "" {
-		return "3000"
-	}
-	return port
-}
-
 // Config controls how the HTTP service listener behaves.
 type Config struct {
-	Addr string
+	Addr  string
+	Agent agent.Config
+	Users users.Config
+	// ProcessJobs runs a background job worker inside the HTTP process. Disable it when
+	// dedicated --worker processes consume the queue.
+	ProcessJobs bool
+	Jobs        jobs.WorkerConfig
+	// ShutdownTimeout bounds how long in-flight requests may run after shutdown begins.
+	ShutdownTimeout time.Duration
 }
 
 // Run starts the HTTP service listener until the provided context is canceled.
@@ -43,19 +49,43 @@ func Run(ctx context.Context, cfg Config) error {
 	}
 	addr := strings.TrimSpace(cfg.Addr)
 	if addr == "" {
-		addr = fmt.Sprintf(":%s", servicePort())
+		addr = defaultAddr
+	}
+	shutdownTimeout := cfg.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
 	}
 
-	usersHandler, err := users.NewUserHandler(ctx)
+	usersHandler, err := users.NewUserHandler(ctx, cfg.Users)
 	if err != nil {
 		return fmt.Errorf("init users handler: %w", err)
 	}
 	defer usersHandler.Close(context.Background())
-	agentHandler, err := agent.NewAgentHandler(ctx, usersHandler)
+	agentHandler, err := agent.NewAgentHandler(ctx, cfg.Agent, usersHandler)
 	if err != nil {
 		return fmt.Errorf("init agent handler: %w", err)
 	}
 	defer agentHandler.Close(context.Background())
+	migrations, err := agentHandler.Migrate(ctx)
+	for _, line := range migrations.Lines() {
+		log.Printf("mongo migration %s", line)
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	dbservice.LogIndexReport(usersHandler.EnsureIndexes(ctx, dbservice.IndexOptions{}))
+	dbservice.LogIndexReport(agentHandler.EnsureIndexes(ctx, dbservice.IndexOptions{}))
+
+	// The worker gets its own context so that it can also be drained when the server
+	// fails to start.
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	defer stopWorker()
+	workerDone := make(chan error, 1)
+	if cfg.ProcessJobs {
+		go func() { workerDone <- agentHandler.NewJobWorker(cfg.Jobs).Run(workerCtx) }()
+	} else {
+		workerDone <- nil
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc(apiVersionPath(""), func(w http.ResponseWriter, r *http.Request) {
@@ -68,6 +98,24 @@ func Run(ctx context.Context, cfg Config) error {
 	})
 	mux.HandleFunc(apiVersionPath("/create/agent"), agentHandler.CreateAgent)
 	mux.HandleFunc(apiVersionPath("/agents"), agentHandler.ListAgents)
+	mux.HandleFunc(apiVersionPath("/agents/{id}/clone"), agentHandler.CloneAgent)
+	mux.HandleFunc(apiVersionPath("/agent/wizard"), agentHandler.DraftPersona)
+	mux.HandleFunc(apiVersionPath("/agent/wizard/accept"), agentHandler.AcceptPersonaDraft)
+	mux.HandleFunc(apiVersionPath("/agent"), agentHandler.UpdateAgent)
+	mux.HandleFunc(apiVersionPath("/agent/versions"), agentHandler.ListAgentVersions)
+	mux.HandleFunc(apiVersionPath("/agent/versions/diff"), agentHandler.DiffAgentVersions)
+	mux.HandleFunc(apiVersionPath("/agent/versions/rollback"), agentHandler.RollbackAgentVersion)
+	mux.HandleFunc(apiVersionPath("/agent/scene"), agentHandler.GenerateAgentScene)
+	mux.HandleFunc(apiVersionPath("/agent/avatar"), agentHandler.UploadAgentAvatar)
+	mux.HandleFunc(apiVersionPath("/agent/portrait/select"), agentHandler.SelectPortrait)
+	mux.HandleFunc(apiVersionPath("/agent/gallery"), agentHandler.ListGalleryPhotos)
+	mux.HandleFunc(apiVersionPath("/agent/gallery/photo"), agentHandler.GalleryPhoto)
+	mux.HandleFunc(apiVersionPath("/agent/card"), agentHandler.ExportAgentCard)
+	mux.HandleFunc(apiVersionPath("/agent/card/import"), agentHandler.ImportAgentCard)
+	if media := agentHandler.MediaHandler(); media != nil {
+		mediaPath := apiVersionPath("/media") + "/"
+		mux.Handle(mediaPath, http.StripPrefix(mediaPath, media))
+	}
 	mux.HandleFunc(apiVersionPath("/login"), usersHandler.Login)
 	mux.HandleFunc(apiVersionPath("/agent/chat/agentid"), agentHandler.ChatWithAgent)
 	mux.HandleFunc(apiVersionPath("/agent/social-profile"), agentHandler.GetAgentSocialProfile)
@@ -85,14 +133,19 @@ func Run(ctx context.Context, cfg Config) error {
 
 	select {
 	case <-ctx.Done():
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// The worker saw the same cancellation and drains while in-flight requests
+		// finish; wait for both before the deferred handler Close runs.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
-		if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.Canceled) {
-			return err
+		shutdownErr := srv.Shutdown(shutdownCtx)
+		if errors.Is(shutdownErr, context.Canceled) {
+			shutdownErr = nil
 		}
-		return nil
+		return errors.Join(shutdownErr, <-workerDone)
 
 	case err := <-errCh:
-		return err
+		// Drain the worker before the deferred handler Close disconnects Mongo.
+		stopWorker()
+		return errors.Join(err, <-workerDone)
 	}
 }
@@ -0,0 +1,82 @@
+package imagegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Style presets understood by Options.Style.
+const (
+	StylePhotoreal    = "photoreal"
+	StyleAnime        = "anime"
+	StyleIllustration = "illustration"
+)
+
+// MaxCandidates caps how many images one Generate call may request.
+const MaxCandidates = 4
+
+const maxNegativeLength = 300
+
+var stylePrompts = map[string]string{
+	StylePhotoreal:    "Render as a photorealistic photograph with natural skin texture, realistic lighting and shallow depth of field.",
+	StyleAnime:        "Render in a clean modern anime style with crisp line art, cel shading and expressive eyes.",
+	StyleIllustration: "Render as a polished digital illustration with painterly brushwork and a soft, cohesive color palette.",
+}
+
+var aspectRatios = map[string]string{
+	"1:1":  "square",
+	"3:4":  "portrait",
+	"4:3":  "landscape",
+	"9:16": "tall portrait",
+	"16:9": "wide landscape",
+}
+
+// Options tunes a Generate call. The zero value asks for one image with no extra guidance.
+type Options struct {
+	// Style is one of the Style constants; empty leaves the style to the prompt.
+	Style string `json:"style,omitempty"`
+	// AspectRatio is one of 1:1, 3:4, 4:3, 9:16 or 16:9.
+	AspectRatio string `json:"aspect_ratio,omitempty"`
+	// Negative lists things the image must not contain.
+	Negative string `json:"negative,omitempty"`
+	// Candidates is how many alternative images to return, 1 to MaxCandidates; zero means 1.
+	Candidates int `json:"candidates,omitempty"`
+	// References are sent ahead of the prompt to keep a subject consistent.
+	References []ReferenceImage `json:"-"`
+}
+
+// Validate reports the first option that is out of range.
+func (o Options) Validate() error {
+	if o.Style != "" {
+		if _, ok := stylePrompts[o.Style]; !ok {
+			return fmt.Errorf("unknown style %q", o.Style)
+		}
+	}
+	if o.AspectRatio != "" {
+		if _, ok := aspectRatios[o.AspectRatio]; !ok {
+			return fmt.Errorf("unsupported aspect ratio %q", o.AspectRatio)
+		}
+	}
+	if len([]rune(o.Negative)) > maxNegativeLength {
+		return fmt.Errorf("negative guidance must be at most %d characters", maxNegativeLength)
+	}
+	if o.Candidates < 0 || o.Candidates > MaxCandidates {
+		return fmt.Errorf("candidates must be between 0 and %d (0 means 1)", MaxCandidates)
+	}
+	return nil
+}
+
+// apply appends the style, framing and negative guidance to prompt.
+func (o Options) apply(prompt string) string {
+	lines := []string{prompt}
+	if style := stylePrompts[o.Style]; style != "" {
+		lines = append(lines, style)
+	}
+	if shape := aspectRatios[o.AspectRatio]; shape != "" {
+		lines = append(lines, fmt.Sprintf("Compose the image in a %s %s aspect ratio.", shape, o.AspectRatio))
+	}
+	if negative := strings.TrimSpace(o.Negative); negative != "" {
+		lines = append(lines, fmt.Sprintf("Do not include: %s.", strings.TrimSuffix(negative, ".")))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -2,8 +2,10 @@ package imagegen
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	genai "google.golang.org/genai"
 )
@@ -46,19 +48,95 @@ func New(ctx context.Context, cfg Config) (*Service, error) {
 // Close releases underlying client resources.
 func (s *Service) Close(ctx context.Context) error { return nil }
 
-// GenerateImage produces an image and returns the raw bytes and mime type.
-func (s *Service) GenerateImage(ctx context.Context, prompt string) ([]byte, string, error) {
+// Image is a generated image.
+type Image struct {
+	Data     []byte
+	MIMEType string
+}
+
+// ReferenceImage is an existing image passed to the model alongside the prompt, for
+// example the base portrait that new images of an agent must stay consistent with.
+type ReferenceImage struct {
+	Data     []byte
+	MIMEType string
+}
+
+// GenerateImageWithReferences produces an image conditioned on the prompt and the provided
+// reference images, which are sent inline ahead of the prompt text.
+func (s *Service) GenerateImageWithReferences(ctx context.Context, prompt string, refs []ReferenceImage) ([]byte, string, error) {
+	images, err := s.Generate(ctx, prompt, Options{References: refs})
+	if err != nil {
+		return nil, "", err
+	}
+	return images[0].Data, images[0].MIMEType, nil
+}
+
+// Generate produces opts.Candidates images for prompt, styled and framed as requested.
+// The model has no native candidate count or aspect-ratio setting for image output, so
+// candidates are separate concurrent requests and framing is expressed in the prompt.
+// Generate succeeds if at least one candidate does.
+func (s *Service) Generate(ctx context.Context, prompt string, opts Options) ([]Image, error) {
 	if s == nil || s.client == nil {
-		return nil, "", fmt.Errorf("image client not initialized")
+		return nil, fmt.Errorf("image client not initialized")
 	}
 	prompt = strings.TrimSpace(prompt)
 	if prompt == "" {
-		return nil, "", fmt.Errorf("prompt is required")
+		return nil, fmt.Errorf("prompt is required")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	contents, err := buildContents(opts.apply(prompt), opts.References)
+	if err != nil {
+		return nil, err
+	}
+
+	count := max(opts.Candidates, 1)
+	results := make([][]Image, count)
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = s.generateOnce(ctx, contents)
+		}()
+	}
+	wg.Wait()
+
+	var images []Image
+	for _, batch := range results {
+		images = append(images, batch...)
+	}
+	if len(images) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return images, nil
+}
+
+func buildContents(prompt string, refs []ReferenceImage) ([]*genai.Content, error) {
+	parts := make([]*genai.Part, 0, len(refs)+1)
+	for i, ref := range refs {
+		if len(ref.Data) == 0 {
+			return nil, fmt.Errorf("reference image %d is empty", i)
+		}
+		mime := strings.TrimSpace(ref.MIMEType)
+		if mime == "" {
+			mime = "image/png"
+		}
+		parts = append(parts, genai.NewPartFromBytes(ref.Data, mime))
 	}
-	resp, err := s.client.Models.GenerateContent(ctx, s.modelName, genai.Text(prompt), nil)
+	parts = append(parts, genai.NewPartFromText(prompt))
+	return []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}, nil
+}
+
+// generateOnce issues one request and returns every inline image in the response.
+func (s *Service) generateOnce(ctx context.Context, contents []*genai.Content) ([]Image, error) {
+	resp, err := s.client.Models.GenerateContent(ctx, s.modelName, contents, nil)
 	if err != nil {
-		return nil, "", fmt.Errorf("generate image: %w", err)
+		return nil, fmt.Errorf("generate image: %w", err)
 	}
+	var images []Image
 	for _, cand := range resp.Candidates {
 		if cand == nil || cand.Content == nil {
 			continue
@@ -71,8 +149,11 @@ func (s *Service) GenerateImage(ctx context.Context, prompt string) ([]byte, str
 			if mime == "" {
 				mime = "image/png"
 			}
-			return part.InlineData.Data, mime, nil
+			images = append(images, Image{Data: part.InlineData.Data, MIMEType: mime})
 		}
 	}
-	return nil, "", fmt.Errorf("gemini response missing image data")
+	if len(images) == 0 {
+		return nil, fmt.Errorf("gemini response missing image data")
+	}
+	return images, nil
 }
@@ -0,0 +1,186 @@
+// Package imageproc validates and normalises images before they are stored: it decodes the
+// bytes, drops any embedded metadata by re-encoding, and produces resized renditions.
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // register decoder
+	"image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// Supported output formats.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+const (
+	defaultQuality = 85
+	// maxPixels rejects decompression bombs before the full image is decoded.
+	maxPixels = 40_000_000
+)
+
+// ErrUnsupported is returned for bytes that are not a decodable image.
+var ErrUnsupported = errors.New("unsupported image data")
+
+// Rendition names one output size. Images are scaled down so their longest side is at
+// most MaxSize; smaller images are never scaled up.
+type Rendition struct {
+	Name    string
+	MaxSize int
+}
+
+// DefaultRenditions are the sizes stored for agent portraits.
+var DefaultRenditions = []Rendition{
+	{Name: "thumb", MaxSize: 128},
+	{Name: "medium", MaxSize: 512},
+	{Name: "full", MaxSize: 1024},
+}
+
+// Options controls Process. The zero value encodes DefaultRenditions as JPEG.
+type Options struct {
+	Format     string
+	Quality    int
+	Renditions []Rendition
+}
+
+// Output is one encoded rendition.
+type Output struct {
+	Name      string
+	Data      []byte
+	MIMEType  string
+	Extension string
+	Width     int
+	Height    int
+}
+
+// Inspect reads only the image header and returns its dimensions and format.
+func Inspect(data []byte) (image.Config, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return image.Config{}, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return image.Config{}, "", fmt.Errorf("%w: %dx%d pixels", ErrUnsupported, cfg.Width, cfg.Height)
+	}
+	return cfg, format, nil
+}
+
+// Decode checks that data is a complete image and returns it with its source format.
+func Decode(data []byte) (image.Image, string, error) {
+	if _, _, err := Inspect(data); err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, format, nil
+}
+
+// Process decodes data and encodes every requested rendition. Re-encoding from pixels
+// means EXIF, text chunks and other metadata in the source never reach the output.
+func Process(data []byte, opts Options) ([]Output, error) {
+	img, _, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	format := strings.ToLower(strings.TrimSpace(opts.Format))
+	if format == "" {
+		format = FormatJPEG
+	}
+	if format != FormatJPEG && format != FormatPNG {
+		return nil, fmt.Errorf("unknown output format %q", opts.Format)
+	}
+	quality := opts.Quality
+	if quality <= 0 || quality > 100 {
+		quality = defaultQuality
+	}
+	renditions := opts.Renditions
+	if len(renditions) == 0 {
+		renditions = DefaultRenditions
+	}
+
+	outputs := make([]Output, 0, len(renditions))
+	for _, r := range renditions {
+		scaled := Fit(img, r.MaxSize)
+		var buf bytes.Buffer
+		out := Output{Name: r.Name, Width: scaled.Bounds().Dx(), Height: scaled.Bounds().Dy()}
+		switch format {
+		case FormatJPEG:
+			err = jpeg.Encode(&buf, flatten(scaled), &jpeg.Options{Quality: quality})
+			out.MIMEType, out.Extension = "image/jpeg", ".jpg"
+		case FormatPNG:
+			err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, scaled)
+			out.MIMEType, out.Extension = "image/png", ".png"
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encode %s rendition: %w", r.Name, err)
+		}
+		out.Data = buf.Bytes()
+		outputs = append(outputs, out)
+	}
+	return outputs, nil
+}
+
+// Fit scales img down so its longest side is at most maxSize, averaging the source
+// pixels covered by each output pixel. img is returned as-is when it already fits.
+func Fit(img image.Image, maxSize int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxSize <= 0 || (w <= maxSize && h <= maxSize) {
+		return img
+	}
+	dw, dh := maxSize, maxSize
+	if w >= h {
+		dh = max(1, h*maxSize/w)
+	} else {
+		dw = max(1, w*maxSize/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0 := b.Min.Y + y*h/dh
+		y1 := max(b.Min.Y+(y+1)*h/dh, y0+1)
+		for x := 0; x < dw; x++ {
+			x0 := b.Min.X + x*w/dw
+			x1 := max(b.Min.X+(x+1)*w/dw, x0+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// flatten composites img over white, since JPEG has no alpha channel.
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
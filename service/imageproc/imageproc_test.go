@@ -0,0 +1,50 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestProcessRenditions(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 90, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs, err := Process(buf.Bytes(), Options{})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	want := map[string][2]int{"thumb": {128, 64}, "medium": {512, 256}, "full": {600, 300}}
+	if len(outputs) != len(want) {
+		t.Fatalf("got %d renditions, want %d", len(outputs), len(want))
+	}
+	for _, out := range outputs {
+		size := want[out.Name]
+		if out.Width != size[0] || out.Height != size[1] {
+			t.Errorf("%s is %dx%d, want %dx%d", out.Name, out.Width, out.Height, size[0], size[1])
+		}
+		if out.MIMEType != "image/jpeg" {
+			t.Errorf("%s has mime type %q", out.Name, out.MIMEType)
+		}
+		if _, format, err := Decode(out.Data); err != nil || format != "jpeg" {
+			t.Errorf("%s does not decode as jpeg: %v %q", out.Name, err, format)
+		}
+	}
+}
+
+func TestProcessRejectsGarbage(t *testing.T) {
+	if _, err := Process([]byte("definitely not an image"), Options{}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
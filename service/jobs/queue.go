@@ -0,0 +1,229 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"buddy-agent/service/dbservice"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Job states persisted in the jobs collection.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const (
+	defaultMaxAttempts = 3
+	retryBaseDelay     = 30 * time.Second
+	// finishedJobRetention is how long done and failed jobs stay around for inspection
+	// before the TTL index removes them.
+	finishedJobRetention = 7 * 24 * time.Hour
+)
+
+// Job is a unit of background work stored in MongoDB until a worker completes it.
+type Job struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Kind        string             `json:"kind" bson:"kind"`
+	AgentID     primitive.ObjectID `json:"agent_id,omitempty" bson:"agent_id,omitempty"`
+	Params      map[string]string  `json:"params,omitempty" bson:"params,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	MaxAttempts int                `json:"max_attempts" bson:"max_attempts"`
+	LastError   string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	RunAfter    time.Time          `json:"run_after" bson:"run_after"`
+	LockedBy    string             `json:"locked_by,omitempty" bson:"locked_by,omitempty"`
+	LockedUntil time.Time          `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	// ExpiresAt is set once the job is done or has failed for good.
+	ExpiresAt time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+// Indexes lists the indexes the jobs collection needs: one per branch of the claim
+// query, and a TTL index that removes finished jobs.
+func Indexes() []dbservice.Index {
+	return []dbservice.Index{
+		{Name: "status_run_after", Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_after", Value: 1}}},
+		{Name: "status_locked_until", Keys: bson.D{{Key: "status", Value: 1}, {Key: "locked_until", Value: 1}}},
+		{Name: "expires_at_ttl", Keys: bson.D{{Key: "expires_at", Value: 1}}, TTL: true},
+	}
+}
+
+// Queue persists jobs in a MongoDB collection so any process can pick them up.
+type Queue struct {
+	collection *mongo.Collection
+}
+
+// NewQueue wraps the provided collection as a job queue.
+func NewQueue(collection *mongo.Collection) *Queue {
+	return &Queue{collection: collection}
+}
+
+// Enqueue stores a pending job and returns its id.
+func (q *Queue) Enqueue(ctx context.Context, job Job) (primitive.ObjectID, error) {
+	if q == nil || q.collection == nil {
+		return primitive.NilObjectID, fmt.Errorf("job queue not initialized")
+	}
+	job.Kind = strings.TrimSpace(job.Kind)
+	if job.Kind == "" {
+		return primitive.NilObjectID, fmt.Errorf("job kind is required")
+	}
+	now := time.Now().UTC()
+	job.ID = primitive.NewObjectID()
+	job.Status = StatusPending
+	job.Attempts = 0
+	job.LastError = ""
+	job.LockedBy = ""
+	job.LockedUntil = time.Time{}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = defaultMaxAttempts
+	}
+	if job.RunAfter.IsZero() {
+		job.RunAfter = now
+	}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	if _, err := q.collection.InsertOne(ctx, job); err != nil {
+		return primitive.NilObjectID, fmt.Errorf("enqueue %s job: %w", job.Kind, err)
+	}
+	return job.ID, nil
+}
+
+// claim atomically leases the oldest runnable job of the given kinds. Jobs whose lease
+// expired (for example because their worker crashed) are runnable again.
+func (q *Queue) claim(ctx context.Context, workerID string, kinds []string, lease time.Duration) (*Job, error) {
+	filter, update := claimQuery(workerID, kinds, lease, time.Now().UTC())
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "run_after", Value: 1}}).
+		SetReturnDocument(options.After)
+	var job Job
+	if err := q.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+	return &job, nil
+}
+
+// claimQuery selects runnable jobs, pending ones that are due and running ones whose lease
+// expired, and leases the one it picks to workerID until now+lease.
+func claimQuery(workerID string, kinds []string, lease time.Duration, now time.Time) (filter, update bson.M) {
+	filter = bson.M{
+		"kind": bson.M{"$in": kinds},
+		"$or": bson.A{
+			bson.M{"status": StatusPending, "run_after": bson.M{"$lte": now}},
+			bson.M{"status": StatusRunning, "locked_until": bson.M{"$lt": now}},
+		},
+	}
+	update = bson.M{
+		"$set": bson.M{
+			"status":       StatusRunning,
+			"locked_by":    workerID,
+			"locked_until": now.Add(lease),
+			"updated_at":   now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	return filter, update
+}
+
+func (q *Queue) complete(ctx context.Context, job *Job) error {
+	now := time.Now().UTC()
+	update := bson.M{
+		"$set": bson.M{
+			"status":     StatusDone,
+			"updated_at": now,
+			"expires_at": now.Add(finishedJobRetention),
+		},
+		"$unset": bson.M{"locked_by": "", "locked_until": ""},
+	}
+	if _, err := q.collection.UpdateOne(ctx, bson.M{"_id": job.ID, "locked_by": job.LockedBy}, update); err != nil {
+		return fmt.Errorf("complete job %s: %w", job.ID.Hex(), err)
+	}
+	return nil
+}
+
+// fail records the error and either schedules a retry with linear backoff or marks the
+// job as permanently failed once it has exhausted its attempts.
+func (q *Queue) fail(ctx context.Context, job *Job, jobErr error) error {
+	update := failureUpdate(job, jobErr, time.Now().UTC())
+	if _, err := q.collection.UpdateOne(ctx, bson.M{"_id": job.ID, "locked_by": job.LockedBy}, update); err != nil {
+		return fmt.Errorf("fail job %s: %w", job.ID.Hex(), err)
+	}
+	return nil
+}
+
+// failureUpdate records jobErr on job and schedules the next attempt, or marks the job
+// failed when it has none left.
+func failureUpdate(job *Job, jobErr error, now time.Time) bson.M {
+	set := bson.M{
+		"last_error": jobErr.Error(),
+		"updated_at": now,
+	}
+	if job.Attempts >= job.MaxAttempts {
+		set["status"] = StatusFailed
+		set["expires_at"] = now.Add(finishedJobRetention)
+	} else {
+		set["status"] = StatusPending
+		set["run_after"] = now.Add(retryDelay(job.Attempts))
+	}
+	return bson.M{
+		"$set":   set,
+		"$unset": bson.M{"locked_by": "", "locked_until": ""},
+	}
+}
+
+// retryDelay is the linear backoff before the attempt after the given one.
+func retryDelay(attempts int) time.Duration {
+	return time.Duration(attempts) * retryBaseDelay
+}
+
+// release returns jobs interrupted by shutdown to the pending state so the next worker
+// picks them up immediately. The interrupted attempt still counts: a job that always
+// outlives the drain window would otherwise be retried forever, so one that has used its
+// last attempt fails instead.
+func (q *Queue) release(ctx context.Context, workerID string, jobs []Job) error {
+	var retry, exhausted []primitive.ObjectID
+	for _, job := range jobs {
+		if job.Attempts >= job.MaxAttempts {
+			exhausted = append(exhausted, job.ID)
+		} else {
+			retry = append(retry, job.ID)
+		}
+	}
+	now := time.Now().UTC()
+	updates := []struct {
+		ids []primitive.ObjectID
+		set bson.M
+	}{
+		{retry, bson.M{"status": StatusPending, "run_after": now}},
+		{exhausted, bson.M{"status": StatusFailed, "expires_at": now.Add(finishedJobRetention)}},
+	}
+	for _, u := range updates {
+		if len(u.ids) == 0 {
+			continue
+		}
+		u.set["last_error"] = "interrupted by shutdown"
+		u.set["updated_at"] = now
+		update := bson.M{
+			"$set":   u.set,
+			"$unset": bson.M{"locked_by": "", "locked_until": ""},
+		}
+		filter := bson.M{"_id": bson.M{"$in": u.ids}, "locked_by": workerID, "status": StatusRunning}
+		if _, err := q.collection.UpdateMany(ctx, filter, update); err != nil {
+			return fmt.Errorf("release interrupted jobs: %w", err)
+		}
+	}
+	return nil
+}
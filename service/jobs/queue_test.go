@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestClaimQueryLeasesDueAndExpiredJobs(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	filter, update := claimQuery("worker-1", []string{"a", "b"}, time.Minute, now)
+
+	branches := filter["$or"].(bson.A)
+	pending := branches[0].(bson.M)
+	expired := branches[1].(bson.M)
+	if pending["status"] != StatusPending || pending["run_after"].(bson.M)["$lte"] != now {
+		t.Fatalf("pending branch = %v", pending)
+	}
+	if expired["status"] != StatusRunning || expired["locked_until"].(bson.M)["$lt"] != now {
+		t.Fatalf("expired lease branch = %v", expired)
+	}
+	set := update["$set"].(bson.M)
+	if set["locked_by"] != "worker-1" || set["locked_until"] != now.Add(time.Minute) || set["status"] != StatusRunning {
+		t.Fatalf("$set = %v", set)
+	}
+	if update["$inc"].(bson.M)["attempts"] != 1 {
+		t.Fatalf("$inc = %v", update["$inc"])
+	}
+}
+
+func TestFailureUpdateBacksOffLinearly(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute} {
+		set := failureUpdate(&Job{Attempts: attempts, MaxAttempts: 3}, errors.New("boom"), now)["$set"].(bson.M)
+		if set["status"] != StatusPending || set["run_after"] != now.Add(want) || set["last_error"] != "boom" {
+			t.Errorf("attempt %d: $set = %v, want retry after %s", attempts, set, want)
+		}
+	}
+
+	set := failureUpdate(&Job{Attempts: 3, MaxAttempts: 3}, errors.New("boom"), now)["$set"].(bson.M)
+	if set["status"] != StatusFailed || set["expires_at"] != now.Add(finishedJobRetention) {
+		t.Fatalf("last attempt: $set = %v", set)
+	}
+	if _, ok := set["run_after"]; ok {
+		t.Fatal("failed job scheduled for another run")
+	}
+}
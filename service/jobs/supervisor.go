@@ -0,0 +1,111 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Supervisor tracks in-flight background work so shutdown can stop accepting new jobs,
+// wait for running ones, and report the ones that did not finish in time.
+type Supervisor struct {
+	mu          sync.Mutex
+	closed      bool
+	inflight    map[primitive.ObjectID]*supervisedJob
+	interrupted map[primitive.ObjectID]bool
+	wg          sync.WaitGroup
+}
+
+type supervisedJob struct {
+	job    Job
+	cancel context.CancelFunc
+}
+
+// NewSupervisor returns a Supervisor that accepts work until Shutdown is called.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		inflight:    make(map[primitive.ObjectID]*supervisedJob),
+		interrupted: make(map[primitive.ObjectID]bool),
+	}
+}
+
+// Go runs fn for the job in a new goroutine with its own timeout. It returns false
+// without running anything once Shutdown has started.
+func (s *Supervisor) Go(job Job, timeout time.Duration, fn func(ctx context.Context)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	s.inflight[job.ID] = &supervisedJob{job: job, cancel: cancel}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.finish(job.ID)
+		defer cancel()
+		fn(ctx)
+	}()
+	return true
+}
+
+// Interrupted reports whether Shutdown canceled the job before it finished.
+func (s *Supervisor) Interrupted(id primitive.ObjectID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interrupted[id]
+}
+
+// Shutdown stops accepting work and waits up to deadline for in-flight jobs. Jobs still
+// running afterwards are canceled and given up to grace to return. It returns the
+// canceled jobs whose handlers returned, which the caller may hand back to the queue, and
+// those still running, which must be left to their lease: another worker could otherwise
+// run them while the old handler is still writing.
+func (s *Supervisor) Shutdown(deadline, grace time.Duration) (stopped, running []Job) {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil, nil
+	case <-time.After(deadline):
+	}
+
+	s.mu.Lock()
+	canceled := make([]Job, 0, len(s.inflight))
+	for id, tracked := range s.inflight {
+		s.interrupted[id] = true
+		tracked.cancel()
+		canceled = append(canceled, tracked.job)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(grace):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range canceled {
+		if _, ok := s.inflight[job.ID]; ok {
+			running = append(running, job)
+		} else {
+			stopped = append(stopped, job)
+		}
+	}
+	return stopped, running
+}
+
+func (s *Supervisor) finish(id primitive.ObjectID) {
+	s.mu.Lock()
+	delete(s.inflight, id)
+	s.mu.Unlock()
+}
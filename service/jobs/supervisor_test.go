@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSupervisorShutdownWaitsForFinishedJobs(t *testing.T) {
+	sup := NewSupervisor()
+	done := make(chan struct{})
+	if !sup.Go(Job{ID: primitive.NewObjectID()}, time.Minute, func(ctx context.Context) {
+		time.Sleep(20 * time.Millisecond)
+		close(done)
+	}) {
+		t.Fatal("supervisor rejected job before shutdown")
+	}
+	if stopped, running := sup.Shutdown(time.Second, time.Second); len(stopped)+len(running) != 0 {
+		t.Fatalf("expected no unfinished jobs, got %d stopped and %d running", len(stopped), len(running))
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("shutdown returned before job finished")
+	}
+}
+
+func TestSupervisorShutdownReturnsInterruptedJobs(t *testing.T) {
+	sup := NewSupervisor()
+	job := Job{ID: primitive.NewObjectID(), Kind: "slow"}
+	canceled := make(chan struct{})
+	sup.Go(job, time.Minute, func(ctx context.Context) {
+		<-ctx.Done()
+		close(canceled)
+	})
+
+	stopped, running := sup.Shutdown(10*time.Millisecond, time.Second)
+	if len(stopped) != 1 || stopped[0].ID != job.ID || len(running) != 0 {
+		t.Fatalf("expected job %s to be stopped, got stopped %+v, running %+v", job.ID.Hex(), stopped, running)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("interrupted job context was not canceled")
+	}
+	if !sup.Interrupted(job.ID) {
+		t.Fatal("job not marked as interrupted")
+	}
+	if sup.Go(Job{ID: primitive.NewObjectID()}, time.Minute, func(context.Context) {}) {
+		t.Fatal("supervisor accepted job after shutdown")
+	}
+}
+
+func TestSupervisorShutdownKeepsJobsThatIgnoreCancel(t *testing.T) {
+	sup := NewSupervisor()
+	job := Job{ID: primitive.NewObjectID(), Kind: "stubborn"}
+	release := make(chan struct{})
+	defer close(release)
+	sup.Go(job, time.Minute, func(ctx context.Context) { <-release })
+
+	stopped, running := sup.Shutdown(10*time.Millisecond, 10*time.Millisecond)
+	if len(stopped) != 0 || len(running) != 1 || running[0].ID != job.ID {
+		t.Fatalf("expected job %s to be reported running, got stopped %+v, running %+v", job.ID.Hex(), stopped, running)
+	}
+}
@@ -0,0 +1,179 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConcurrency  = 4
+	defaultPollInterval = 2 * time.Second
+	defaultJobTimeout   = 2 * time.Minute
+	defaultDrainTimeout = 30 * time.Second
+	leaseGracePeriod    = 30 * time.Second
+	// cancelGracePeriod is how long drain waits for canceled handlers to return.
+	cancelGracePeriod  = 5 * time.Second
+	queueUpdateTimeout = 5 * time.Second
+)
+
+// Handler processes a single job. Returning an error schedules a retry.
+type Handler func(ctx context.Context, job Job) error
+
+// WorkerConfig controls how a Worker polls and executes jobs.
+type WorkerConfig struct {
+	ID           string
+	Concurrency  int
+	PollInterval time.Duration
+	JobTimeout   time.Duration
+	DrainTimeout time.Duration
+}
+
+// Worker claims jobs from a Queue and dispatches them to registered handlers.
+type Worker struct {
+	queue    *Queue
+	cfg      WorkerConfig
+	handlers map[string]Handler
+}
+
+// NewWorker prepares a Worker for the queue, filling in defaults for unset config values.
+func NewWorker(queue *Queue, cfg WorkerConfig) *Worker {
+	cfg.ID = strings.TrimSpace(cfg.ID)
+	if cfg.ID == "" {
+		cfg.ID = defaultWorkerID()
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.JobTimeout <= 0 {
+		cfg.JobTimeout = defaultJobTimeout
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = defaultDrainTimeout
+	}
+	return &Worker{queue: queue, cfg: cfg, handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler for a job kind.
+func (w *Worker) Handle(kind string, handler Handler) {
+	w.handlers[kind] = handler
+}
+
+// Run claims and executes jobs until ctx is canceled. It then stops claiming new jobs,
+// waits up to DrainTimeout for in-flight jobs, cancels the rest and releases those whose
+// handlers returned back to the queue. Jobs whose handlers ignore the cancellation keep
+// their lease and are picked up again once it expires.
+func (w *Worker) Run(ctx context.Context) error {
+	if w == nil || w.queue == nil {
+		return fmt.Errorf("worker not initialized")
+	}
+	kinds := w.kinds()
+	if len(kinds) == 0 {
+		return fmt.Errorf("worker has no job handlers registered")
+	}
+	lease := w.cfg.JobTimeout + leaseGracePeriod
+	log.Printf("worker %s processing %s with concurrency %d", w.cfg.ID, strings.Join(kinds, ", "), w.cfg.Concurrency)
+
+	slots := make(chan struct{}, w.cfg.Concurrency)
+	supervisor := NewSupervisor()
+	for {
+		select {
+		case <-ctx.Done():
+			return w.drain(supervisor)
+		case slots <- struct{}{}:
+		}
+		job, err := w.queue.claim(ctx, w.cfg.ID, kinds, lease)
+		if err != nil || job == nil {
+			<-slots
+			if err != nil && ctx.Err() == nil {
+				log.Printf("worker %s: %v", w.cfg.ID, err)
+			}
+			select {
+			case <-ctx.Done():
+				return w.drain(supervisor)
+			case <-time.After(w.cfg.PollInterval):
+			}
+			continue
+		}
+		// Jobs run detached from the Run context so a shutdown signal lets them finish.
+		started := supervisor.Go(*job, w.cfg.JobTimeout, func(jobCtx context.Context) {
+			defer func() { <-slots }()
+			w.execute(jobCtx, supervisor, job)
+		})
+		if !started {
+			<-slots
+		}
+	}
+}
+
+func (w *Worker) execute(ctx context.Context, supervisor *Supervisor, job *Job) {
+	err := w.runHandler(ctx, job)
+	updateCtx, updateCancel := context.WithTimeout(context.Background(), queueUpdateTimeout)
+	defer updateCancel()
+	if err == nil {
+		if err := w.queue.complete(updateCtx, job); err != nil {
+			log.Printf("worker %s: %v", w.cfg.ID, err)
+		}
+		return
+	}
+	if supervisor.Interrupted(job.ID) {
+		// drain releases interrupted jobs back to the queue.
+		return
+	}
+	log.Printf("%s job %s failed (attempt %d/%d): %v", job.Kind, job.ID.Hex(), job.Attempts, job.MaxAttempts, err)
+	if err := w.queue.fail(updateCtx, job, err); err != nil {
+		log.Printf("worker %s: %v", w.cfg.ID, err)
+	}
+}
+
+func (w *Worker) runHandler(ctx context.Context, job *Job) (err error) {
+	handler, ok := w.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler registered for %s", job.Kind)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return handler(ctx, *job)
+}
+
+func (w *Worker) drain(supervisor *Supervisor) error {
+	stopped, running := supervisor.Shutdown(w.cfg.DrainTimeout, cancelGracePeriod)
+	if len(stopped)+len(running) == 0 {
+		log.Printf("worker %s drained", w.cfg.ID)
+		return nil
+	}
+	log.Printf("worker %s drain timed out after %s; releasing %d canceled jobs", w.cfg.ID, w.cfg.DrainTimeout, len(stopped))
+	if len(running) > 0 {
+		log.Printf("worker %s: %d jobs did not stop within %s and stay leased until their lease expires", w.cfg.ID, len(running), cancelGracePeriod)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queueUpdateTimeout)
+	defer cancel()
+	return w.queue.release(ctx, w.cfg.ID, stopped)
+}
+
+func (w *Worker) kinds() []string {
+	kinds := make([]string, 0, len(w.handlers))
+	for kind := range w.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || strings.TrimSpace(host) == "" {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
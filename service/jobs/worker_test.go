@@ -0,0 +1,24 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunHandlerRecoversPanics(t *testing.T) {
+	w := NewWorker(nil, WorkerConfig{ID: "test"})
+	w.Handle("panics", func(context.Context, Job) error { panic("nil map") })
+	w.Handle("fails", func(context.Context, Job) error { return errors.New("boom") })
+
+	if err := w.runHandler(context.Background(), &Job{Kind: "panics"}); err == nil || !strings.Contains(err.Error(), "handler panic: nil map") {
+		t.Fatalf("panicking handler returned %v", err)
+	}
+	if err := w.runHandler(context.Background(), &Job{Kind: "fails"}); err == nil || err.Error() != "boom" {
+		t.Fatalf("failing handler returned %v", err)
+	}
+	if err := w.runHandler(context.Background(), &Job{Kind: "unknown"}); err == nil {
+		t.Fatal("expected an error for a kind without a handler")
+	}
+}
@@ -64,9 +64,21 @@ func NewClient(cfg Config) (*Client, error) {
 	}, nil
 }
 
+// Image is an inline image sent to the model alongside a prompt.
+type Image struct {
+	Data     []byte
+	MIMEType string
+}
+
 // SendPrompt stores the provided role/prompt in the running history and issues a request that includes
 // the full conversation for better responses.
 func (c *Client) SendPrompt(ctx context.Context, role, prompt string) (string, error) {
+	return c.SendPromptWithImages(ctx, role, prompt, nil)
+}
+
+// SendPromptWithImages behaves like SendPrompt but attaches the images as inline parts so the
+// model can see them. Only the text is kept in the history.
+func (c *Client) SendPromptWithImages(ctx context.Context, role, prompt string, images []Image) (string, error) {
 	if c == nil {
 		return "", fmt.Errorf("client is nil")
 	}
@@ -75,8 +87,13 @@ func (c *Client) SendPrompt(ctx context.Context, role, prompt string) (string, e
 		return "", err
 	}
 
+	parts := []genai.Part{genai.Text(userMsg.Content)}
+	for _, img := range images {
+		parts = append(parts, genai.Blob{MIMEType: img.MIMEType, Data: img.Data})
+	}
+
 	c.appendAndSnapshot(userMsg)
-	resp, err := c.chat.SendMessage(ctx, genai.Text(userMsg.Content))
+	resp, err := c.chat.SendMessage(ctx, parts...)
 	if err != nil {
 		return "", fmt.Errorf("google api error: %w", err)
 	}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// tempPrefix marks files that Put is still writing; List skips them.
+const tempPrefix = ".upload-"
+
+// Local stores objects as files below a directory and serves them over HTTP itself, so
+// the service can run without any cloud credentials.
+type Local struct {
+	root    *os.Root
+	prefix  string
+	baseURL string
+}
+
+// NewLocal opens (creating if needed) cfg.Dir as the storage root.
+func NewLocal(cfg Config) (*Local, error) {
+	dir := strings.TrimSpace(cfg.Dir)
+	if dir == "" {
+		return nil, fmt.Errorf("local storage directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create storage directory: %w", err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("open storage directory: %w", err)
+	}
+	return &Local{root: root, prefix: normalizePrefix(cfg.Prefix), baseURL: baseURLOrDefault(cfg.BaseURL)}, nil
+}
+
+// Put streams r into a temporary file next to the object and renames it into place, so
+// readers never see a partially written object.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (string, error) {
+	if l == nil || l.root == nil {
+		return "", fmt.Errorf("storage service not initialized")
+	}
+	full, err := fullKey(l.prefix, key)
+	if err != nil {
+		return "", err
+	}
+	if err := l.root.MkdirAll(path.Dir(full), 0o755); err != nil {
+		return "", fmt.Errorf("create object directory: %w", err)
+	}
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	tmp := path.Join(path.Dir(full), tempPrefix+hex.EncodeToString(suffix))
+	file, err := l.root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("create object: %w", err)
+	}
+	written, err := io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("object %q is %d bytes, expected %d", key, written, size)
+	}
+	if err == nil {
+		err = l.root.Rename(tmp, full)
+	}
+	if err != nil {
+		_ = l.root.Remove(tmp)
+		return "", fmt.Errorf("write object: %w", err)
+	}
+	return servedURL(l.baseURL, full), nil
+}
+
+// Get opens the file stored under key.
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	if l == nil || l.root == nil {
+		return nil, ObjectInfo{}, fmt.Errorf("storage service not initialized")
+	}
+	full, err := fullKey(l.prefix, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	file, err := l.root.Open(full)
+	if err != nil {
+		return nil, ObjectInfo{}, localError(key, err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, localError(key, err)
+	}
+	return file, localInfo(key, stat), nil
+}
+
+// Stat describes the file stored under key.
+func (l *Local) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if l == nil || l.root == nil {
+		return ObjectInfo{}, fmt.Errorf("storage service not initialized")
+	}
+	full, err := fullKey(l.prefix, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := l.root.Stat(full)
+	if err != nil {
+		return ObjectInfo{}, localError(key, err)
+	}
+	return localInfo(key, stat), nil
+}
+
+// UploadImage writes data to a file named after the object key.
+func (l *Local) UploadImage(ctx context.Context, objectName, contentType string, data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("image data is empty")
+	}
+	if strings.TrimSpace(contentType) == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return l.Put(ctx, imageName(objectName, contentType), bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// ReadImage reads the file behind a URL returned by UploadImage.
+func (l *Local) ReadImage(ctx context.Context, uri string) ([]byte, string, error) {
+	key, err := l.Key(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	body, info, err := l.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return readAll(body, info)
+}
+
+// URL returns the served URL of key.
+func (l *Local) URL(key string) (string, error) {
+	full, err := fullKey(l.prefix, key)
+	if err != nil {
+		return "", err
+	}
+	return servedURL(l.baseURL, full), nil
+}
+
+// Key returns the key behind a served URL.
+func (l *Local) Key(uri string) (string, error) {
+	full, err := keyFromServedURL(l.baseURL, uri)
+	if err != nil {
+		return "", err
+	}
+	return relativeKey(l.prefix, full)
+}
+
+// List walks the files below the prefix directory in lexical order.
+func (l *Local) List(ctx context.Context, fn func(ObjectInfo) error) error {
+	if l == nil || l.root == nil {
+		return fmt.Errorf("storage service not initialized")
+	}
+	dir := strings.TrimSuffix(l.prefix, "/")
+	if dir == "" {
+		dir = "."
+	}
+	err := fs.WalkDir(l.root.FS(), dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && name == dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		key, err := relativeKey(l.prefix, name)
+		if err != nil {
+			return err
+		}
+		return fn(localInfo(key, stat))
+	})
+	if err != nil {
+		return fmt.Errorf("list objects: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the file stored under key.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if l == nil || l.root == nil {
+		return fmt.Errorf("storage service not initialized")
+	}
+	full, err := fullKey(l.prefix, key)
+	if err != nil {
+		return err
+	}
+	if err := l.root.Remove(full); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete object: %w", err)
+	}
+	return nil
+}
+
+// MediaHandler serves stored files by key. os.Root keeps requests inside the storage
+// directory and only files below the prefix are served; directories and files Put is
+// still writing are not found, so the handler never lists the keys it stores.
+func (l *Local) MediaHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if _, err := relativeKey(l.prefix, name); err != nil || !fs.ValidPath(name) || strings.HasPrefix(path.Base(name), tempPrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := l.root.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil || !stat.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		http.ServeContent(w, r, name, stat.ModTime(), file)
+	})
+}
+
+// ResolveURL returns uri unchanged; local objects are served as stored.
+func (l *Local) ResolveURL(ctx context.Context, uri string) (string, error) { return uri, nil }
+
+// PrivateMedia is false: local storage stands in for a public bucket.
+func (l *Local) PrivateMedia() bool { return false }
+
+// localInfo describes a stored file. Its content type follows from the key's extension
+// and is empty when the extension is unknown.
+func localInfo(key string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{Key: key, Size: stat.Size(), ContentType: mime.TypeByExtension(path.Ext(key)), Modified: stat.ModTime()}
+}
+
+func localError(key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return fmt.Errorf("open object: %w", err)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps objects in process memory. It is meant for tests and throwaway local runs;
+// everything is lost on restart.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	prefix  string
+	baseURL string
+}
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func (o memoryObject) info(key string) ObjectInfo {
+	return ObjectInfo{Key: key, Size: int64(len(o.data)), ContentType: o.contentType, Modified: o.modified}
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory(cfg Config) *Memory {
+	return &Memory{
+		objects: make(map[string]memoryObject),
+		prefix:  normalizePrefix(cfg.Prefix),
+		baseURL: baseURLOrDefault(cfg.BaseURL),
+	}
+}
+
+// Put reads r into memory.
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (string, error) {
+	full, err := fullKey(m.prefix, key)
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("read object: %w", err)
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return "", fmt.Errorf("object %q is %d bytes, expected %d", key, len(data), size)
+	}
+	m.mu.Lock()
+	m.objects[full] = memoryObject{data: data, contentType: contentTypeOr(key, contentType), modified: time.Now()}
+	m.mu.Unlock()
+	return servedURL(m.baseURL, full), nil
+}
+
+// Get returns a reader over the stored bytes.
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	obj, err := m.lookup(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.info(key), nil
+}
+
+// Stat describes the object stored under key.
+func (m *Memory) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	obj, err := m.lookup(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return obj.info(key), nil
+}
+
+func (m *Memory) lookup(key string) (memoryObject, error) {
+	full, err := fullKey(m.prefix, key)
+	if err != nil {
+		return memoryObject{}, err
+	}
+	m.mu.RLock()
+	obj, ok := m.objects[full]
+	m.mu.RUnlock()
+	if !ok {
+		return memoryObject{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return obj, nil
+}
+
+// UploadImage stores a copy of data.
+func (m *Memory) UploadImage(ctx context.Context, objectName, contentType string, data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("image data is empty")
+	}
+	if strings.TrimSpace(contentType) == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return m.Put(ctx, imageName(objectName, contentType), bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// ReadImage returns a copy of the object behind uri.
+func (m *Memory) ReadImage(ctx context.Context, uri string) ([]byte, string, error) {
+	key, err := m.Key(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	body, info, err := m.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return readAll(body, info)
+}
+
+// URL returns the served URL of key.
+func (m *Memory) URL(key string) (string, error) {
+	full, err := fullKey(m.prefix, key)
+	if err != nil {
+		return "", err
+	}
+	return servedURL(m.baseURL, full), nil
+}
+
+// Key returns the key behind a served URL.
+func (m *Memory) Key(uri string) (string, error) {
+	full, err := keyFromServedURL(m.baseURL, uri)
+	if err != nil {
+		return "", err
+	}
+	return relativeKey(m.prefix, full)
+}
+
+// List reports every stored object in key order.
+func (m *Memory) List(ctx context.Context, fn func(ObjectInfo) error) error {
+	m.mu.RLock()
+	infos := make([]ObjectInfo, 0, len(m.objects))
+	for full, obj := range m.objects {
+		if key, err := relativeKey(m.prefix, full); err == nil {
+			infos = append(infos, obj.info(key))
+		}
+	}
+	m.mu.RUnlock()
+	slices.SortFunc(infos, func(a, b ObjectInfo) int { return strings.Compare(a.Key, b.Key) })
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete drops the object stored under key.
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	full, err := fullKey(m.prefix, key)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.objects, full)
+	m.mu.Unlock()
+	return nil
+}
+
+// MediaHandler serves stored objects by key.
+func (m *Memory) MediaHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		m.mu.RLock()
+		obj, ok := m.objects[key]
+		m.mu.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		http.ServeContent(w, r, key, obj.modified, bytes.NewReader(obj.data))
+	})
+}
+
+// ResolveURL returns uri unchanged; memory objects are served as stored.
+func (m *Memory) ResolveURL(ctx context.Context, uri string) (string, error) { return uri, nil }
+
+// PrivateMedia is false: memory storage stands in for a public bucket.
+func (m *Memory) PrivateMedia() bool { return false }
@@ -3,38 +3,55 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"path"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 const (
-	defaultBucketName = "ai-contacts"
-	defaultPrefix     = "base-faces/"
+	defaultBucketName       = "ai-contacts"
+	defaultPrefix           = "base-faces/"
+	defaultCompatibleRegion = "auto"
+	defaultPresignTTL       = 15 * time.Minute
+	proxyCacheControl       = "private, max-age=3600"
 )
 
-// Config controls how the S3 storage service behaves.
-type Config struct {
-	Bucket string
-	Prefix string
-	Region string
-}
-
 // Service uploads generated assets to the configured S3 bucket/prefix.
 type Service struct {
-	client   *s3.Client
-	uploader *manager.Uploader
-	bucket   string
-	prefix   string
-	region   string
+	client    *s3.Client
+	uploader  *manager.Uploader
+	bucket    string
+	prefix    string
+	region    string
+	endpoint  *url.URL
+	pathStyle bool
+	publicURL string
+	urlMode   string
+	presign   *s3.PresignClient
+	signTTL   time.Duration
+	// mediaBase and mediaKey build and sign the URLs served by MediaHandler in proxy mode.
+	mediaBase string
+	mediaKey  []byte
 }
 
 // New constructs a Service that uploads to the ai-contacts/base-faces prefix by default.
+// Setting cfg.Endpoint targets an S3-compatible store such as MinIO or Cloudflare R2
+// instead of AWS; bucket region detection is skipped there.
 func New(ctx context.Context, cfg Config) (*Service, error) {
 	if ctx == nil {
 		ctx = context.Background()
@@ -43,15 +60,20 @@ func New(ctx context.Context, cfg Config) (*Service, error) {
 	if bucket == "" {
 		bucket = defaultBucketName
 	}
-	prefix := strings.TrimSpace(cfg.Prefix)
-	if prefix == "" {
-		prefix = defaultPrefix
-	}
-	prefix = strings.Trim(prefix, "/")
-	if prefix != "" {
-		prefix += "/"
-	}
+	prefix := normalizePrefix(cfg.Prefix)
 	requestedRegion := strings.TrimSpace(cfg.Region)
+	var endpoint *url.URL
+	if raw := strings.TrimSpace(cfg.Endpoint); raw != "" {
+		parsed, err := url.Parse(strings.TrimRight(raw, "/"))
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid storage endpoint %q", raw)
+		}
+		endpoint = parsed
+		if requestedRegion == "" {
+			// S3-compatible stores ignore the region but request signing still needs one.
+			requestedRegion = defaultCompatibleRegion
+		}
+	}
 
 	loadOpts := []func(*awsconfig.LoadOptions) error{}
 	if requestedRegion != "" {
@@ -61,63 +83,405 @@ func New(ctx context.Context, cfg Config) (*Service, error) {
 	if err != nil {
 		return nil, fmt.Errorf("load aws config: %w", err)
 	}
-	client := s3.NewFromConfig(awsCfg)
+	clientOpts := func(o *s3.Options) {
+		o.UsePathStyle = cfg.UsePathStyle
+		if endpoint != nil {
+			o.BaseEndpoint = aws.String(endpoint.String())
+		}
+	}
+	client := s3.NewFromConfig(awsCfg, clientOpts)
 	effectiveRegion := awsCfg.Region
-	if detectedRegion, err := manager.GetBucketRegion(ctx, client, bucket); err == nil && strings.TrimSpace(detectedRegion) != "" {
-		effectiveRegion = detectedRegion
-		awsCfg.Region = detectedRegion
-		client = s3.NewFromConfig(awsCfg)
+	if endpoint == nil {
+		if detectedRegion, err := manager.GetBucketRegion(ctx, client, bucket); err == nil && strings.TrimSpace(detectedRegion) != "" {
+			effectiveRegion = detectedRegion
+			awsCfg.Region = detectedRegion
+			client = s3.NewFromConfig(awsCfg, clientOpts)
+		}
+	}
+	urlMode := strings.ToLower(strings.TrimSpace(cfg.URLMode))
+	switch urlMode {
+	case "":
+		urlMode = URLModePublic
+	case URLModePublic, URLModePresign, URLModeProxy:
+	default:
+		return nil, fmt.Errorf("unknown storage url mode %q", cfg.URLMode)
+	}
+	signTTL := cfg.PresignTTL
+	if signTTL <= 0 {
+		signTTL = defaultPresignTTL
+	}
+	mediaKey := []byte(cfg.MediaSecret)
+	if len(mediaKey) == 0 {
+		mediaKey = make([]byte, 32)
+		_, _ = rand.Read(mediaKey)
 	}
 	return &Service{
-		client:   client,
-		uploader: manager.NewUploader(client),
-		bucket:   bucket,
-		prefix:   prefix,
-		region:   effectiveRegion,
+		mediaBase: baseURLOrDefault(cfg.BaseURL),
+		mediaKey:  mediaKey,
+		urlMode:   urlMode,
+		presign:   s3.NewPresignClient(client),
+		signTTL:   signTTL,
+		client:    client,
+		uploader:  manager.NewUploader(client),
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    effectiveRegion,
+		endpoint:  endpoint,
+		pathStyle: cfg.UsePathStyle,
+		publicURL: strings.TrimRight(strings.TrimSpace(cfg.PublicBaseURL), "/"),
 	}, nil
 }
 
-// UploadImage stores the provided image bytes in S3 using the configured prefix and returns the s3:// URI.
-func (s *Service) UploadImage(ctx context.Context, objectName, contentType string, data []byte) (string, error) {
+// Put streams r to key. The upload manager sends large bodies as a multipart upload, so
+// the payload is never buffered whole.
+func (s *Service) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (string, error) {
 	if s == nil || s.uploader == nil {
 		return "", fmt.Errorf("storage service not initialized")
 	}
-	objectName = strings.TrimSpace(objectName)
-	if objectName == "" {
-		return "", fmt.Errorf("object name is required")
+	full, err := fullKey(s.prefix, key)
+	if err != nil {
+		return "", err
+	}
+	contentType = contentTypeOr(key, contentType)
+	input := &s3.PutObjectInput{Bucket: &s.bucket, Key: &full, Body: r, ContentType: &contentType}
+	if size >= 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	if _, err := s.uploader.Upload(ctx, input); err != nil {
+		return "", fmt.Errorf("upload to s3: %w", err)
+	}
+	return s.storedURL(full), nil
+}
+
+// URL returns the URL Put returns for key.
+func (s *Service) URL(key string) (string, error) {
+	full, err := fullKey(s.prefix, key)
+	if err != nil {
+		return "", err
+	}
+	return s.storedURL(full), nil
+}
+
+func (s *Service) storedURL(full string) string {
+	if s.urlMode != URLModePublic {
+		// Private objects are referenced by s3:// URI and turned into a readable URL by
+		// ResolveURL each time they are served.
+		return fmt.Sprintf("s3://%s/%s", s.bucket, full)
+	}
+	return s.httpURL(full)
+}
+
+// Get opens the object stored under key.
+func (s *Service) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	if s == nil || s.client == nil {
+		return nil, ObjectInfo{}, fmt.Errorf("storage service not initialized")
+	}
+	full, err := fullKey(s.prefix, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &full})
+	if err != nil {
+		return nil, ObjectInfo{}, s3Error("download from s3", key, err)
+	}
+	info := ObjectInfo{
+		Key:         key,
+		Size:        aws.ToInt64(out.ContentLength),
+		ContentType: strings.TrimSpace(aws.ToString(out.ContentType)),
+		Modified:    aws.ToTime(out.LastModified),
+	}
+	return out.Body, info, nil
+}
+
+// Stat describes the object stored under key with a HEAD request.
+func (s *Service) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if s == nil || s.client == nil {
+		return ObjectInfo{}, fmt.Errorf("storage service not initialized")
+	}
+	full, err := fullKey(s.prefix, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &full})
+	if err != nil {
+		return ObjectInfo{}, s3Error("stat s3 object", key, err)
+	}
+	return ObjectInfo{
+		Key:         key,
+		Size:        aws.ToInt64(out.ContentLength),
+		ContentType: strings.TrimSpace(aws.ToString(out.ContentType)),
+		Modified:    aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// List pages through every object below the configured prefix.
+func (s *Service) List(ctx context.Context, fn func(ObjectInfo) error) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("storage service not initialized")
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &s.prefix})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list s3 objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			key, err := relativeKey(s.prefix, aws.ToString(obj.Key))
+			if err != nil {
+				continue
+			}
+			if err := fn(ObjectInfo{Key: key, Size: aws.ToInt64(obj.Size), Modified: aws.ToTime(obj.LastModified)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Delete removes the object stored under key.
+func (s *Service) Delete(ctx context.Context, key string) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("storage service not initialized")
+	}
+	full, err := fullKey(s.prefix, key)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: &full}); err != nil {
+		return fmt.Errorf("delete from s3: %w", err)
 	}
-	contentType = strings.TrimSpace(contentType)
+	return nil
+}
+
+// UploadImage stores the provided image bytes below the configured prefix and returns
+// their URL, or an s3:// URI when the bucket is private.
+func (s *Service) UploadImage(ctx context.Context, objectName, contentType string, data []byte) (string, error) {
 	if len(data) == 0 {
 		return "", fmt.Errorf("image data is empty")
 	}
-	key := path.Join(s.prefix, objectName)
-	if !strings.Contains(key, ".") {
-		key += ".png"
+	if strings.TrimSpace(contentType) == "" {
+		contentType = http.DetectContentType(data)
 	}
-	body := bytes.NewReader(data)
-	if err := s.upload(ctx, key, contentType, body); err != nil {
+	return s.Put(ctx, imageName(objectName, contentType), bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// ReadImage downloads an object previously returned by UploadImage, accepting any URL
+// form Key understands, and returns its bytes and content type.
+func (s *Service) ReadImage(ctx context.Context, uri string) ([]byte, string, error) {
+	key, err := s.Key(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	body, info, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return readAll(body, info)
+}
+
+// Key returns the key, relative to the prefix, behind a public URL, s3:// URI, presigned
+// URL or media proxy path.
+func (s *Service) Key(uri string) (string, error) {
+	full, err := s.keyFromURL(uri)
+	if err != nil {
 		return "", err
 	}
-	return s.httpURL(key), nil
+	return relativeKey(s.prefix, full)
 }
 
-func (s *Service) upload(ctx context.Context, key, contentType string, body io.Reader) error {
-	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:      &s.bucket,
-		Key:         &key,
-		Body:        body,
-		ContentType: &contentType,
-	})
+// s3Error maps a missing object to ErrNotFound.
+func s3Error(op, key string, err error) error {
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// ResolveURL turns a stored object reference into a URL a client can fetch: a short-lived
+// presigned GET URL or a signed media proxy URL in the private modes, and uri itself
+// otherwise.
+func (s *Service) ResolveURL(ctx context.Context, uri string) (string, error) {
+	if s == nil || uri == "" || s.urlMode == URLModePublic {
+		return uri, nil
+	}
+	key, err := s.keyFromURL(uri)
+	if err != nil {
+		return "", err
+	}
+	if s.urlMode == URLModeProxy {
+		return s.signedMediaURL(key, time.Now().Add(s.signTTL)), nil
+	}
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &key}, s3.WithPresignExpires(s.signTTL))
 	if err != nil {
-		return fmt.Errorf("upload to s3: %w", err)
+		return "", fmt.Errorf("presign %s: %w", key, err)
 	}
-	return nil
+	return req.URL, nil
 }
 
+// PrivateMedia reports whether MediaHandler serves a private bucket.
+func (s *Service) PrivateMedia() bool { return true }
+
+// signedMediaURL is the proxy URL of key, valid until expires.
+func (s *Service) signedMediaURL(key string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{"expires": {exp}, "sig": {s.mediaSignature(key, exp)}}
+	return servedURL(s.mediaBase, key) + "?" + query.Encode()
+}
+
+func (s *Service) mediaSignature(key, expires string) string {
+	mac := hmac.New(sha256.New, s.mediaKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyMediaRequest reports whether r carries the unexpired signature ResolveURL added
+// for the object it requests.
+func (s *Service) VerifyMediaRequest(r *http.Request) bool {
+	if s == nil || len(s.mediaKey) == 0 {
+		return false
+	}
+	query := r.URL.Query()
+	exp, sig := query.Get("expires"), query.Get("sig")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	return hmac.Equal([]byte(sig), []byte(s.mediaSignature(key, exp)))
+}
+
+// MediaHandler proxies GET requests for objects below the configured prefix in proxy mode
+// and is nil otherwise. Responses carry an ETag so clients can revalidate cheaply.
+func (s *Service) MediaHandler() http.Handler {
+	if s == nil || s.urlMode != URLModeProxy {
+		return nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if key == "" || !strings.HasPrefix(key, s.prefix) {
+			http.NotFound(w, r)
+			return
+		}
+		input := &s3.GetObjectInput{Bucket: &s.bucket, Key: &key}
+		if etag := r.Header.Get("If-None-Match"); etag != "" {
+			input.IfNoneMatch = &etag
+		}
+		out, err := s.client.GetObject(r.Context(), input)
+		if err != nil {
+			var respErr *smithyhttp.ResponseError
+			if errors.As(err, &respErr) {
+				switch respErr.HTTPStatusCode() {
+				case http.StatusNotModified:
+					w.Header().Set("Cache-Control", proxyCacheControl)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				case http.StatusNotFound:
+					http.NotFound(w, r)
+					return
+				}
+			}
+			http.Error(w, "failed to load object", http.StatusBadGateway)
+			return
+		}
+		defer out.Body.Close()
+		header := w.Header()
+		header.Set("Cache-Control", proxyCacheControl)
+		if out.ContentType != nil {
+			header.Set("Content-Type", *out.ContentType)
+		}
+		if out.ContentLength != nil {
+			header.Set("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+		}
+		if out.ETag != nil {
+			header.Set("ETag", *out.ETag)
+		}
+		if out.LastModified != nil {
+			header.Set("Last-Modified", out.LastModified.UTC().Format(http.TimeFormat))
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = io.Copy(w, out.Body)
+	})
+}
+
+// httpURL is the public URL of key: below PublicBaseURL when configured (for a CDN or an
+// R2 public domain), otherwise the bucket URL on the custom endpoint or on AWS.
 func (s *Service) httpURL(key string) string {
+	if s.publicURL != "" {
+		return s.publicURL + "/" + key
+	}
+	if s.endpoint != nil {
+		if s.pathStyle {
+			return fmt.Sprintf("%s/%s/%s", s.endpoint.String(), s.bucket, key)
+		}
+		return fmt.Sprintf("%s://%s.%s%s/%s", s.endpoint.Scheme, s.bucket, s.endpoint.Host, s.endpoint.Path, key)
+	}
 	region := strings.TrimSpace(s.region)
+	if s.pathStyle {
+		if region == "" || region == "us-east-1" {
+			return fmt.Sprintf("https://s3.amazonaws.com/%s/%s", s.bucket, key)
+		}
+		return fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", region, s.bucket, key)
+	}
 	if region == "" || region == "us-east-1" {
 		return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", s.bucket, key)
 	}
 	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, region, key)
 }
+
+// keyFromURL maps a URL produced by httpURL, or an s3://bucket/key URI, back to its object key.
+func (s *Service) keyFromURL(uri string) (string, error) {
+	uri = strings.TrimSpace(uri)
+	if s.publicURL != "" && strings.HasPrefix(uri, s.publicURL+"/") {
+		return s.checkKey(uri, strings.TrimPrefix(uri, s.publicURL+"/"))
+	}
+	if s.urlMode == URLModeProxy {
+		// Clients may hand back the proxied URLs they were given.
+		if key, err := keyFromServedURL(baseURLOrDefault(s.mediaBase), uri); err == nil {
+			return s.checkKey(uri, key)
+		}
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("parse object url: %w", err)
+	}
+	objectPath := strings.TrimPrefix(parsed.Path, "/")
+	switch {
+	case parsed.Scheme == "s3" && parsed.Host == s.bucket:
+		return s.checkKey(uri, objectPath)
+	case s.endpoint != nil && parsed.Host == s.endpoint.Host:
+		endpointPath := strings.Trim(s.endpoint.Path, "/")
+		if endpointPath != "" {
+			objectPath = strings.TrimPrefix(objectPath, endpointPath+"/")
+		}
+		if rest, ok := strings.CutPrefix(objectPath, s.bucket+"/"); ok {
+			return s.checkKey(uri, rest)
+		}
+	case s.endpoint != nil && parsed.Host == s.bucket+"."+s.endpoint.Host:
+		endpointPath := strings.Trim(s.endpoint.Path, "/")
+		if endpointPath != "" {
+			objectPath = strings.TrimPrefix(objectPath, endpointPath+"/")
+		}
+		return s.checkKey(uri, objectPath)
+	case parsed.Scheme == "https" && strings.HasPrefix(parsed.Host, s.bucket+".s3."):
+		return s.checkKey(uri, objectPath)
+	case parsed.Scheme == "https" && strings.HasPrefix(parsed.Host, "s3.") && strings.HasSuffix(parsed.Host, ".amazonaws.com"):
+		if rest, ok := strings.CutPrefix(objectPath, s.bucket+"/"); ok {
+			return s.checkKey(uri, rest)
+		}
+	}
+	return "", fmt.Errorf("url %q is not in bucket %s", uri, s.bucket)
+}
+
+func (s *Service) checkKey(uri, key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("url %q has no object key", uri)
+	}
+	return key, nil
+}
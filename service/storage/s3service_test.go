@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3URLRoundTrip(t *testing.T) {
+	minio, _ := url.Parse("http://localhost:9000")
+	r2, _ := url.Parse("https://account.r2.cloudflarestorage.com")
+	cases := []struct {
+		name    string
+		service *Service
+		want    string
+	}{
+		{"aws", &Service{bucket: "faces", region: "eu-west-1"}, "https://faces.s3.eu-west-1.amazonaws.com/base-faces/a.png"},
+		{"aws path style", &Service{bucket: "faces", region: "us-east-1", pathStyle: true}, "https://s3.amazonaws.com/faces/base-faces/a.png"},
+		{"minio", &Service{bucket: "faces", endpoint: minio, pathStyle: true}, "http://localhost:9000/faces/base-faces/a.png"},
+		{"r2 virtual host", &Service{bucket: "faces", endpoint: r2}, "https://faces.account.r2.cloudflarestorage.com/base-faces/a.png"},
+		{"cdn", &Service{bucket: "faces", endpoint: r2, publicURL: "https://cdn.example.com/img"}, "https://cdn.example.com/img/base-faces/a.png"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.service.httpURL("base-faces/a.png")
+			if got != tc.want {
+				t.Fatalf("httpURL = %q, want %q", got, tc.want)
+			}
+			key, err := tc.service.keyFromURL(got)
+			if err != nil || key != "base-faces/a.png" {
+				t.Fatalf("keyFromURL(%q) = %q, %v", got, key, err)
+			}
+			if key, err := tc.service.keyFromURL("s3://faces/base-faces/a.png"); err != nil || key != "base-faces/a.png" {
+				t.Fatalf("keyFromURL(s3://) = %q, %v", key, err)
+			}
+		})
+	}
+}
+
+func TestS3ProxyResolveURL(t *testing.T) {
+	s := &Service{bucket: "faces", prefix: "base-faces", urlMode: URLModeProxy, mediaBase: "https://api.example.com/api/v1/media", mediaKey: []byte("secret"), signTTL: time.Minute}
+	got, err := s.ResolveURL(context.Background(), "s3://faces/base-faces/a.png")
+	if err != nil || !strings.HasPrefix(got, "https://api.example.com/api/v1/media/base-faces/a.png?") {
+		t.Fatalf("ResolveURL = %q, %v", got, err)
+	}
+	key, err := s.keyFromURL(got)
+	if err != nil || key != "base-faces/a.png" {
+		t.Fatalf("keyFromURL(%q) = %q, %v", got, key, err)
+	}
+	// Signed URLs handed back by clients map to the stored reference again.
+	if stored := s.storedURL(key); stored != "s3://faces/base-faces/a.png" {
+		t.Fatalf("storedURL(%q) = %q", key, stored)
+	}
+	if _, err := s.ResolveURL(context.Background(), "s3://other/base-faces/a.png"); err == nil {
+		t.Fatal("expected an error for an object in another bucket")
+	}
+
+	// The handler is mounted with the media path stripped.
+	request := func(uri string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, uri, nil)
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api/v1/media/")
+		return r
+	}
+	if !s.VerifyMediaRequest(request(got)) {
+		t.Fatalf("signed url %q rejected", got)
+	}
+	for name, uri := range map[string]string{
+		"other key": strings.Replace(got, "a.png", "b.png", 1),
+		"unsigned":  "https://api.example.com/api/v1/media/base-faces/a.png",
+		"expired":   s.signedMediaURL("base-faces/a.png", time.Now().Add(-time.Second)),
+	} {
+		if s.VerifyMediaRequest(request(uri)) {
+			t.Errorf("%s: %q accepted", name, uri)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Supported values for Config.Backend.
+const (
+	BackendS3     = "s3"
+	BackendLocal  = "local"
+	BackendMemory = "memory"
+)
+
+// URL modes for the S3 backend, set through Config.URLMode.
+const (
+	// URLModePublic returns plain object URLs and requires a world-readable bucket.
+	URLModePublic = "public"
+	// URLModePresign keeps objects private and resolves them to presigned GET URLs.
+	URLModePresign = "presign"
+	// URLModeProxy keeps objects private and serves them through MediaServer.
+	URLModeProxy = "proxy"
+)
+
+// DefaultMediaPath is where the HTTP server mounts MediaServer handlers, and the default
+// base for URLs returned by the local and in-memory backends.
+const DefaultMediaPath = "/api/v1/media"
+
+// ErrNotFound is returned by Get and Stat for keys with no object.
+var ErrNotFound = errors.New("object not found")
+
+// Store is a blob store for agent images and other user content. Keys are relative to
+// the configured prefix.
+type Store interface {
+	// Put streams r to key and returns the URL clients should use to fetch it. size is the
+	// length of r, or -1 when unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (string, error)
+	// Get opens the object stored under key. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// Stat describes the object stored under key without reading it.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List calls fn for every stored object.
+	List(ctx context.Context, fn func(ObjectInfo) error) error
+	// Delete removes the object stored under key. Deleting a missing object is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// UploadImage stores data under objectName, adding the extension for contentType when
+	// the name has none, and returns its URL.
+	UploadImage(ctx context.Context, objectName, contentType string, data []byte) (string, error)
+	// ReadImage fetches an object by a URL previously returned from UploadImage.
+	ReadImage(ctx context.Context, uri string) ([]byte, string, error)
+	// ResolveURL converts a URL returned by UploadImage into one a client can fetch right
+	// now. It is the identity for stores whose URLs are already public.
+	ResolveURL(ctx context.Context, uri string) (string, error)
+	// Key returns the object key behind a URL returned by Put, UploadImage or ResolveURL.
+	Key(uri string) (string, error)
+	// URL returns the URL Put returned for key, the form documents store. Together with
+	// Key it turns a resolved URL back into its stored form.
+	URL(key string) (string, error)
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	Modified    time.Time
+}
+
+// MediaServer is implemented by stores whose objects are served by this process rather
+// than by the storage provider.
+type MediaServer interface {
+	// MediaHandler serves objects by key; mount it with the base path stripped. It is nil
+	// when the store is not currently configured to serve media.
+	MediaHandler() http.Handler
+	// PrivateMedia reports whether served objects may only reach authenticated users.
+	PrivateMedia() bool
+}
+
+// MediaVerifier is implemented by MediaServers whose private media URLs carry a
+// short-lived signature, so that browsers can load them in <img src> without an
+// Authorization header.
+type MediaVerifier interface {
+	// VerifyMediaRequest reports whether r, with the base path stripped, carries a valid
+	// unexpired signature for the object it requests.
+	VerifyMediaRequest(r *http.Request) bool
+}
+
+// Config selects and configures a storage backend. Bucket, Region, Endpoint,
+// UsePathStyle and PublicBaseURL apply to S3, Dir to the local backend, and BaseURL and
+// MediaSecret to media served by MediaServer, including S3 in proxy mode.
+type Config struct {
+	Backend string
+	Bucket  string
+	Prefix  string
+	Region  string
+	// Endpoint is the base URL of an S3-compatible API, e.g. http://localhost:9000 for
+	// MinIO or https://<account>.r2.cloudflarestorage.com for R2. Empty means AWS.
+	Endpoint string
+	// UsePathStyle addresses objects as endpoint/bucket/key instead of bucket.endpoint/key,
+	// which MinIO and most local setups require.
+	UsePathStyle bool
+	// PublicBaseURL, when set, is the prefix of returned object URLs, e.g. a CDN domain.
+	PublicBaseURL string
+	// URLMode is one of the URLMode constants; empty means URLModePublic.
+	URLMode string
+	// PresignTTL is how long presigned URLs stay valid; it defaults to 15 minutes.
+	PresignTTL time.Duration
+	Dir        string
+	// BaseURL is the base of URLs for media served by this process, e.g.
+	// https://api.example.com/api/v1/media. It defaults to the host-relative
+	// DefaultMediaPath.
+	BaseURL string
+	// MediaSecret signs proxied media URLs. Replicas behind one load balancer must share
+	// it; when empty each process signs with a random key of its own.
+	MediaSecret string
+}
+
+// Open builds the Store named by cfg.Backend, defaulting to S3.
+func Open(ctx context.Context, cfg Config) (Store, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", BackendS3:
+		return New(ctx, cfg)
+	case BackendLocal:
+		return NewLocal(cfg)
+	case BackendMemory:
+		return NewMemory(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// normalizePrefix returns prefix without surrounding slashes and with a trailing slash
+// when non-empty, falling back to defaultPrefix when unset.
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// ContentKey returns the content-addressed object name for data: the hex SHA-256 of the
+// bytes, fanned out by its first two characters, with the extension for contentType.
+// Identical images share one object and an object's bytes never change under its key.
+func ContentKey(contentType string, data []byte) string {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	return "blobs/" + digest[:2] + "/" + digest + Extension(contentType)
+}
+
+// Extension returns the file extension, with its dot, conventionally used for
+// contentType, or ".bin" when the type is unknown.
+func Extension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ".bin"
+	}
+	switch mediaType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// imageName gives object names without an extension the one that matches contentType.
+func imageName(objectName, contentType string) string {
+	objectName = strings.TrimSpace(objectName)
+	if objectName != "" && path.Ext(objectName) == "" {
+		objectName += Extension(contentType)
+	}
+	return objectName
+}
+
+// fullKey joins prefix and key after checking that key is a clean relative path, so
+// callers cannot reach objects outside the prefix in a shared bucket or directory.
+func fullKey(prefix, key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return prefix + key, nil
+}
+
+// relativeKey strips prefix from a full object key.
+func relativeKey(prefix, full string) (string, error) {
+	key, ok := strings.CutPrefix(full, prefix)
+	if !ok || key == "" {
+		return "", fmt.Errorf("object %q is not below prefix %q", full, prefix)
+	}
+	return key, nil
+}
+
+// contentTypeOr returns contentType, or the type implied by key's extension when it is
+// empty.
+func contentTypeOr(key, contentType string) string {
+	if contentType = strings.TrimSpace(contentType); contentType != "" {
+		return contentType
+	}
+	if byExt := mime.TypeByExtension(path.Ext(key)); byExt != "" {
+		return byExt
+	}
+	return "application/octet-stream"
+}
+
+// readAll drains an object opened by Get.
+func readAll(body io.ReadCloser, info ObjectInfo) ([]byte, string, error) {
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("read object: %w", err)
+	}
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
+
+// baseURLOrDefault trims the configured public base for served media.
+func baseURLOrDefault(baseURL string) string {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		return DefaultMediaPath
+	}
+	return baseURL
+}
+
+// servedURL is the URL of key below baseURL.
+func servedURL(baseURL, key string) string {
+	return baseURL + "/" + key
+}
+
+// keyFromServedURL maps a URL produced by servedURL back to its key. Absolute and
+// path-only forms of the URL are both accepted.
+func keyFromServedURL(baseURL, uri string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("parse media base url: %w", err)
+	}
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return "", fmt.Errorf("parse object url: %w", err)
+	}
+	basePath := strings.TrimRight(base.Path, "/") + "/"
+	if !strings.HasPrefix(parsed.Path, basePath) {
+		return "", fmt.Errorf("url %q is not served from %s", uri, baseURL)
+	}
+	key := strings.TrimPrefix(parsed.Path, basePath)
+	if key == "" {
+		return "", fmt.Errorf("url %q has no object key", uri)
+	}
+	return key, nil
+}
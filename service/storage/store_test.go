@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServedStoresRoundTrip(t *testing.T) {
+	local, err := NewLocal(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	stores := map[string]Store{
+		"local":  local,
+		"memory": NewMemory(Config{BaseURL: "http://localhost:3000/api/v1/media/"}),
+	}
+	payload := []byte("\x89PNG\r\n\x1a\nnot really a png")
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			uri, err := store.UploadImage(ctx, "agent/base/full", "image/png", payload)
+			if err != nil {
+				t.Fatalf("UploadImage: %v", err)
+			}
+			if !strings.HasSuffix(uri, "/api/v1/media/base-faces/agent/base/full.png") {
+				t.Fatalf("unexpected url %q", uri)
+			}
+			data, contentType, err := store.ReadImage(ctx, uri)
+			if err != nil {
+				t.Fatalf("ReadImage: %v", err)
+			}
+			if !bytes.Equal(data, payload) || contentType != "image/png" {
+				t.Fatalf("read back %q (%s)", data, contentType)
+			}
+
+			handler := http.StripPrefix(DefaultMediaPath+"/", store.(MediaServer).MediaHandler())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DefaultMediaPath+"/base-faces/agent/base/full.png", nil))
+			body, _ := io.ReadAll(rec.Result().Body)
+			if rec.Code != http.StatusOK || !bytes.Equal(body, payload) {
+				t.Fatalf("served %d %q", rec.Code, body)
+			}
+			for _, dir := range []string{"/", "/base-faces/", "/base-faces/agent/base"} {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DefaultMediaPath+dir, nil))
+				if rec.Code != http.StatusNotFound {
+					t.Fatalf("GET %s = %d, want 404", dir, rec.Code)
+				}
+			}
+
+			if _, _, err := store.ReadImage(ctx, "https://elsewhere.example/base-faces/x.png"); err == nil {
+				t.Fatal("expected foreign url to be rejected")
+			}
+
+			var keys []string
+			if err := store.List(ctx, func(info ObjectInfo) error {
+				keys = append(keys, info.Key)
+				return nil
+			}); err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(keys) != 1 || keys[0] != "agent/base/full.png" {
+				t.Fatalf("listed %q", keys)
+			}
+			if err := store.Delete(ctx, "../other/full.png"); err == nil {
+				t.Fatal("expected key outside the prefix to be rejected")
+			}
+			if err := store.Delete(ctx, keys[0]); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := store.Stat(ctx, keys[0]); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Stat after Delete = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestLocalMediaHandlerServesOnlyThePrefix(t *testing.T) {
+	dir := t.TempDir()
+	local, err := NewLocal(Config{Dir: dir})
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("private"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	local.MediaHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/notes.txt", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("GET of a file beside the prefix = %d, want 404", rec.Code)
+	}
+}
+
+func TestContentKey(t *testing.T) {
+	a := ContentKey("image/jpeg", []byte("a"))
+	if !strings.HasPrefix(a, "blobs/ca/ca978112") || !strings.HasSuffix(a, ".jpg") {
+		t.Fatalf("unexpected key %q", a)
+	}
+	if b := ContentKey("image/png", []byte("a")); strings.TrimSuffix(b, ".png") != strings.TrimSuffix(a, ".jpg") {
+		t.Fatalf("same bytes got different digests: %q, %q", a, b)
+	}
+	if ext := Extension("application/x-unknown"); ext != ".bin" {
+		t.Fatalf("Extension(unknown) = %q", ext)
+	}
+}
+
+func TestStreamingPutGet(t *testing.T) {
+	local, err := NewLocal(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	stores := map[string]Store{"local": local, "memory": NewMemory(Config{})}
+	payload := strings.Repeat("knowledge base line\n", 1<<12)
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			uri, err := store.Put(ctx, "docs/notes.txt", strings.NewReader(payload), int64(len(payload)), "")
+			if err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if key, err := store.Key(uri); err != nil || key != "docs/notes.txt" {
+				t.Fatalf("Key(%q) = %q, %v", uri, key, err)
+			}
+			if stored, err := store.URL("docs/notes.txt"); err != nil || stored != uri {
+				t.Fatalf("URL = %q, %v; want %q", stored, err, uri)
+			}
+			info, err := store.Stat(ctx, "docs/notes.txt")
+			if err != nil || info.Size != int64(len(payload)) || !strings.HasPrefix(info.ContentType, "text/plain") {
+				t.Fatalf("Stat = %+v, %v", info, err)
+			}
+			body, _, err := store.Get(ctx, "docs/notes.txt")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			data, err := io.ReadAll(body)
+			body.Close()
+			if err != nil || string(data) != payload {
+				t.Fatalf("read back %d bytes, %v", len(data), err)
+			}
+			if _, err := store.Put(ctx, "docs/short.txt", strings.NewReader("abc"), 10, "text/plain"); err == nil {
+				t.Fatal("expected a size mismatch to fail")
+			}
+			if _, err := store.Stat(ctx, "docs/short.txt"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Stat of failed Put = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -30,7 +30,7 @@ func (h *UserHandler) FetchUserByToken(ctx context.Context, token string) (*User
 
 	dbCtx, cancel := context.WithTimeout(ctx, dbRequestTimeout)
 	defer cancel()
-	collection := h.db.Client().Database(mongoDatabaseName()).Collection(usersCollection)
+	collection := h.db.Database().Collection(usersCollection)
 
 	var stored User
 	if err := collection.FindOne(dbCtx, bson.M{"uid": verified.UID}).Decode(&stored); err != nil {
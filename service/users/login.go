@@ -50,7 +50,7 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	dbCtx, cancel := context.WithTimeout(ctx, dbRequestTimeout)
 	defer cancel()
-	collection := h.db.Client().Database(mongoDatabaseName()).Collection(usersCollection)
+	collection := h.db.Database().Collection(usersCollection)
 
 	now := time.Now().UTC()
 	filter := bson.M{"uid": userRecord.UID}
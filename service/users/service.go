@@ -5,24 +5,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"strings"
 	"time"
 
 	"buddy-agent/service/dbservice"
 	firebase "firebase.google.com/go/v4"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 const (
-	envMongoDatabase   = "MONGO_DB_NAME"
-	defaultMongoDBName = "buddy-agent"
-	usersCollection    = "users"
-	dbRequestTimeout   = 5 * time.Second
+	usersCollection  = "users"
+	dbRequestTimeout = 5 * time.Second
 )
 
+// Config carries the settings NewUserHandler needs. Firebase picks up its credentials
+// from GOOGLE_APPLICATION_CREDENTIALS.
+type Config struct {
+	Mongo dbservice.Config
+}
+
 // NewUserHandler builds the users handler with Firebase Auth and Mongo dependencies.
-func NewUserHandler(ctx context.Context) (*UserHandler, error) {
-	svc, err := dbservice.New(ctx)
+func NewUserHandler(ctx context.Context, cfg Config) (*UserHandler, error) {
+	svc, err := dbservice.NewWithConfig(ctx, cfg.Mongo)
 	if err != nil {
 		return nil, err
 	}
@@ -37,6 +40,21 @@ func NewUserHandler(ctx context.Context) (*UserHandler, error) {
 	return &UserHandler{db: svc, auth: authClient}, nil
 }
 
+// Indexes lists the indexes of the users collection. Logins upsert by uid, so it must
+// be unique.
+func Indexes() []dbservice.CollectionIndexes {
+	return []dbservice.CollectionIndexes{
+		{Collection: usersCollection, Indexes: []dbservice.Index{
+			{Name: "uid_unique", Keys: bson.D{{Key: "uid", Value: 1}}, Unique: true},
+		}},
+	}
+}
+
+// EnsureIndexes reconciles the users collection with Indexes.
+func (h *UserHandler) EnsureIndexes(ctx context.Context, opts dbservice.IndexOptions) (dbservice.IndexReport, error) {
+	return h.db.EnsureIndexes(ctx, Indexes(), opts)
+}
+
 // Close releases resources held by the handler.
 func (h *UserHandler) Close(ctx context.Context) error {
 	if h == nil {
@@ -48,13 +66,6 @@ func (h *UserHandler) Close(ctx context.Context) error {
 	return h.db.Close(ctx)
 }
 
-func mongoDatabaseName() string {
-	if name := strings.TrimSpace(os.Getenv(envMongoDatabase)); name != "" {
-		return name
-	}
-	return defaultMongoDBName
-}
-
 func respondJSONError(w http.ResponseWriter, status int, msg string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"buddy-agent/service/agent"
+	"buddy-agent/service/dbservice"
+	"buddy-agent/service/jobs"
+)
+
+// Config controls how the dedicated worker process behaves.
+type Config struct {
+	Agent agent.Config
+	Jobs  jobs.WorkerConfig
+}
+
+// Run processes background jobs without serving HTTP until the provided context is canceled.
+// Cancellation stops claiming new jobs and drains the in-flight ones before returning.
+func Run(ctx context.Context, cfg Config) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	agentHandler, err := agent.NewAgentHandler(ctx, cfg.Agent, nil)
+	if err != nil {
+		return fmt.Errorf("init agent handler: %w", err)
+	}
+	defer agentHandler.Close(context.Background())
+	migrations, err := agentHandler.Migrate(ctx)
+	for _, line := range migrations.Lines() {
+		log.Printf("mongo migration %s", line)
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	dbservice.LogIndexReport(agentHandler.EnsureIndexes(ctx, dbservice.IndexOptions{}))
+
+	return agentHandler.NewJobWorker(cfg.Jobs).Run(ctx)
+}